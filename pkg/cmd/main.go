@@ -17,9 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -28,6 +34,7 @@ import (
 	"github.com/rkosegi/universal-exporter/pkg/internal/services"
 	"github.com/rkosegi/universal-exporter/pkg/types"
 	"github.com/rkosegi/yaml-toolkit/fluent"
+	"github.com/samber/lo"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -57,6 +64,24 @@ func loadConfig(cfgFile string) (*types.Config, error) {
 		Load(cfgFile).Result(), nil
 }
 
+// newHttpClients creates HTTP client services of default client and of all named client profiles.
+// Profiles are based on default configuration, not on configuration of default client.
+func newHttpClients(config *types.Config, logger *slog.Logger, r prometheus.Registerer) (map[string]types.HttpClientService, error) {
+	hcs := map[string]types.HttpClientService{
+		types.DefaultHttpClientName: services.NewHttpClient(types.DefaultHttpClientName, *config.HttpClient, logger, r),
+	}
+	for name, hcc := range config.HttpClients {
+		if _, exists := hcs[name]; exists {
+			return nil, fmt.Errorf("HTTP client profile name is reserved: %s", name)
+		}
+		hcc = fluent.NewConfigHelper[types.HttpClientServiceConfig]().
+			Add(server.DefaultConfig().HttpClient).
+			Add(hcc).Result()
+		hcs[name] = services.NewHttpClient(name, *hcc, logger, r)
+	}
+	return hcs, nil
+}
+
 func healthHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -100,11 +125,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	hcs := services.NewHttpClient(*config.HttpClient, logger, r)
-	if err = hcs.Start(); err != nil {
-		logger.Error("Couldn't initialize HTTP client service", "err", err)
+	hcs, err := newHttpClients(config, logger, r)
+	if err != nil {
+		logger.Error("Couldn't create HTTP client services", "err", err)
 		os.Exit(1)
 	}
+	for name, hc := range hcs {
+		if err = hc.Start(); err != nil {
+			logger.Error("Couldn't initialize HTTP client service", "client", name, "err", err)
+			os.Exit(1)
+		}
+	}
 
 	if err = r.Register(server.NewExporter(config, logger, hcs, ms)); err != nil {
 		logger.Error("Couldn't register "+name, "err", err)
@@ -162,12 +193,36 @@ func main() {
 	http.Handle("/", landingPageHandler)
 	http.Handle(*config.Server.MetricsPath, metricHandler)
 	http.Handle(*config.Server.HealthEndpoint, healthHandler())
+	if config.Server.Admin != nil {
+		adminHandler, err := server.NewAdminHandler(config.Server.Admin, hcs, logger)
+		if err != nil {
+			logger.Error("Couldn't create admin endpoints", "err", err)
+			os.Exit(1)
+		}
+		http.Handle(strings.TrimSuffix(lo.FromPtrOr(config.Server.Admin.Path, types.DefaultAdminPath), "/")+"/", adminHandler)
+	}
 
 	srv := &http.Server{
 		ReadHeaderTimeout: 10 * time.Second,
 	}
-	if err = web.ListenAndServe(srv, toolkitFlags, logger); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		logger.Info("Shutting down server")
+		if err := srv.Shutdown(context.Background()); err != nil {
+			logger.Warn("Error shutting down server", "err", err)
+		}
+	}()
+
+	if err = web.ListenAndServe(srv, toolkitFlags, logger); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Error("Error starting server", "err", err)
 		os.Exit(1)
 	}
+
+	for name, hc := range hcs {
+		if err = hc.Close(); err != nil {
+			logger.Warn("Couldn't close HTTP client service", "client", name, "err", err)
+		}
+	}
 }
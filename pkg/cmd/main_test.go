@@ -0,0 +1,80 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rkosegi/universal-exporter/pkg/internal/server"
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+func TestNewHttpClients(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+	config := server.DefaultConfig()
+	config.HttpClients = map[string]*types.HttpClientServiceConfig{
+		"a": {Auth: &types.AuthConfig{Bearer: &types.BearerAuthConfig{Token: lo.ToPtr("a")}}},
+		"b": {Auth: &types.AuthConfig{Bearer: &types.BearerAuthConfig{Token: lo.ToPtr("b")}}},
+	}
+	hcs, err := newHttpClients(config, slog.New(slog.DiscardHandler), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, exp := range map[string]string{types.DefaultHttpClientName: "", "a": "Bearer a", "b": "Bearer b"} {
+		hc, ok := hcs[name]
+		if !ok {
+			t.Fatalf("client %s not created", name)
+		}
+		if err = hc.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = hc.Close()
+		})
+		resp, err := (&http.Client{Transport: hc.RoundTripper()}).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != exp {
+			t.Errorf("client %s: expected authorization %q, got %q", name, exp, body)
+		}
+	}
+	if len(hcs) != 3 {
+		t.Errorf("expected 3 clients, got %d", len(hcs))
+	}
+}
+
+func TestNewHttpClientsReservedName(t *testing.T) {
+	config := server.DefaultConfig()
+	config.HttpClients = map[string]*types.HttpClientServiceConfig{types.DefaultHttpClientName: {}}
+	_, err := newHttpClients(config, slog.New(slog.DiscardHandler), prometheus.NewRegistry())
+	if err == nil || !strings.Contains(err.Error(), types.DefaultHttpClientName) {
+		t.Errorf("expected error for reserved profile name, got %v", err)
+	}
+}
@@ -18,10 +18,13 @@ package ops
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/rkosegi/universal-exporter/pkg/types"
 	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
@@ -51,12 +54,86 @@ type (
 		// Names and values can use template.
 		Headers map[string]string
 
-		// ParseJson flag indicating whether to parse response body as JSON into data tree
+		// Body is optional request body. It's either templated string that is sent as-is,
+		// or mapping with "ref" key pointing to node within data tree, which is serialized as JSON.
+		Body *pipeline.ValOrRef `yaml:"body,omitempty"`
+
+		// Form is optional map of form fields, sent as application/x-www-form-urlencoded request body.
+		// Values can use template. Form can't be used together with Body.
+		Form map[string]string `yaml:"form,omitempty"`
+
+		// ParseJson flag indicating whether to parse response body as JSON into data tree.
+		// Deprecated: use Parse instead.
 		ParseJson *bool `yaml:"parseJson,omitempty"`
 
+		// Parse is mode used to parse response body into data tree.
+		// Parsed data are stored in child node named after the mode, e.g. "json" or "xml".
+		Parse *parseMode `yaml:"parse,omitempty"`
+
+		// XPath is optional map of names to XPath expressions, evaluated against XML document.
+		// Results are stored in child node "xpath".
+		XPath map[string]xpathSpec `yaml:"xpath,omitempty"`
+
+		// Selectors is map of names to selectors used to extract values from HTML document.
+		// Results are stored in child node "html".
+		Selectors map[string]htmlSelectorSpec `yaml:"selectors,omitempty"`
+
+		// Csv configures parsing of CSV document.
+		// Rows are stored as list of maps in child node "csv".
+		Csv *csvParseSpec `yaml:"csv,omitempty"`
+
+		// Client is name of HTTP client profile to use. When omitted, default client is used.
+		Client *string `yaml:"client,omitempty"`
+
+		// Auth overrides authentication configured in HttpClient service for this request.
+		Auth *types.AuthConfig `yaml:"auth,omitempty"`
+
 		// StoreTo is path within the global data where parsed response is stored
 		StoreTo string `yaml:"storeTo"`
 	}
+
+	// csvParseSpec configures how CSV document is parsed
+	csvParseSpec struct {
+		// Delimiter is field delimiter, "," by default. Use "\t" for TSV.
+		Delimiter *string `yaml:"delimiter,omitempty"`
+
+		// Header flag whether first row contains column names. Default value is true.
+		// When disabled, columns are named by their index as "col0", "col1" and so on.
+		Header *bool `yaml:"header,omitempty"`
+
+		// Comment is character that starts comment line. Comment lines are skipped.
+		Comment *string `yaml:"comment,omitempty"`
+
+		// Types maps column names to type hints. Supported types are "string" (default), "int", "float" and "bool".
+		Types map[string]string `yaml:"types,omitempty"`
+	}
+
+	// xpathSpec selects value(s) from XML document using XPath expression.
+	xpathSpec struct {
+		// Expr is XPath expression
+		Expr string `yaml:"expr"`
+
+		// List flag whether to extract values from all matching nodes as list.
+		// By default, only first matching node is used.
+		// Expressions that don't evaluate to node-set, such as count(), always result in single value.
+		List *bool `yaml:"list,omitempty"`
+	}
+
+	// htmlSelectorSpec selects value(s) from HTML document, using either XPath or CSS selector.
+	htmlSelectorSpec struct {
+		// XPath is XPath expression
+		XPath *string `yaml:"xpath,omitempty"`
+
+		// Css is CSS selector
+		Css *string `yaml:"css,omitempty"`
+
+		// Attr is name of attribute to extract. When omitted, text content of node is extracted.
+		Attr *string `yaml:"attr,omitempty"`
+
+		// List flag whether to extract values from all matching nodes as list.
+		// By default, only first matching node is used.
+		List *bool `yaml:"list,omitempty"`
+	}
 )
 
 func (h *httpFetchOpFactory) ForArgs(ctx pipeline.ClientContext, args pipeline.StrKeysAnyValues) pipeline.Action {
@@ -66,22 +143,73 @@ func (h *httpFetchOpFactory) ForArgs(ctx pipeline.ClientContext, args pipeline.S
 }
 
 func (h *httpFetchOp) String() string {
-	return fmt.Sprintf("HttpFetch[Method: %v, Url: %s]", h.Method, h.Url)
+	if h.Auth != nil {
+		return fmt.Sprintf("HttpFetch[Method: %s, Url: %s, Auth: %s]", strOrDef(h.Method, http.MethodGet), h.Url, h.Auth)
+	}
+	return fmt.Sprintf("HttpFetch[Method: %s, Url: %s]", strOrDef(h.Method, http.MethodGet), h.Url)
+}
+
+func (h *httpFetchOp) mode() parseMode {
+	if h.Parse != nil {
+		return *h.Parse
+	}
+	if h.ParseJson != nil && *h.ParseJson {
+		return parseModeJson
+	}
+	return ""
+}
+
+// requestBody creates request body (if any) along with its default content type.
+func (h *httpFetchOp) requestBody(ctx pipeline.ActionContext) (io.Reader, string, error) {
+	if len(h.Form) > 0 {
+		if h.Body != nil {
+			return nil, "", errors.New("body and form are mutually exclusive")
+		}
+		ss := ctx.Snapshot()
+		vals := url.Values{}
+		for k, v := range h.Form {
+			vals.Set(k, ctx.TemplateEngine().RenderLenient(v, ss))
+		}
+		return strings.NewReader(vals.Encode()), "application/x-www-form-urlencoded", nil
+	}
+	if h.Body == nil {
+		return nil, "", nil
+	}
+	if len(h.Body.Ref) > 0 {
+		n := ctx.Data().Get(pp.MustParse(h.Body.Ref))
+		if n == nil {
+			return nil, "", fmt.Errorf("cannot find node at '%s'", h.Body.Ref)
+		}
+		data, err := json.Marshal(n.AsAny())
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(data), "application/json", nil
+	}
+	return strings.NewReader(h.Body.Resolve(ctx)), "", nil
 }
 
 func (h *httpFetchOp) doWithResponse(ctx pipeline.ActionContext, resp *types.ParsedHttpResponse) error {
 	c := dom.ContainerNode()
 	c.AddValue("status", dom.LeafNode(resp.StatusCode))
 	c.AddValue("body", dom.LeafNode(string(resp.Body)))
+	c.AddValue("url", dom.LeafNode(resp.Url))
+	c.AddValue("proto", dom.LeafNode(resp.Proto))
+	c.AddValue("contentLength", dom.LeafNode(resp.ContentLength))
+	c.AddValue("stale", dom.LeafNode(resp.Stale))
 	hc := c.AddContainer("headers")
-	for k, v := range h.Headers {
-		hc.AddValue(k, dom.LeafNode(v))
+	for k, v := range resp.Header {
+		hl := dom.ListNode()
+		for _, hv := range v {
+			hl.Append(dom.LeafNode(hv))
+		}
+		hc.AddValue(http.CanonicalHeaderKey(k), hl)
 	}
-	if h.ParseJson != nil && *h.ParseJson {
-		if jd, err := dom.DecodeReader(bytes.NewReader(resp.Body), dom.DefaultJsonDecoder); err != nil {
+	if mode := h.mode(); len(mode) > 0 {
+		if fn, ok := bodyParsers[mode]; !ok {
+			return fmt.Errorf("unknown parse mode: %s", mode)
+		} else if err := fn(h, resp.Body, c); err != nil {
 			return err
-		} else {
-			c.AddValue("json", jd)
 		}
 	}
 	ctx.Data().Set(pp.MustParse(h.StoreTo), c)
@@ -97,6 +225,8 @@ func (h *httpFetchOp) Do(ctx pipeline.ActionContext) error {
 		resp       *http.Response
 		cachedResp *types.ParsedHttpResponse
 		hcs        types.HttpClientService
+		body       io.Reader
+		ct         string
 	)
 	ss := ctx.Snapshot()
 	m := http.MethodGet
@@ -104,13 +234,18 @@ func (h *httpFetchOp) Do(ctx pipeline.ActionContext) error {
 		m = *h.Method
 	}
 	url := ctx.TemplateEngine().RenderLenient(h.Url, ss)
-	if svc := ctx.Ext().GetService("HttpClient"); svc == nil {
-		return errors.New("service not found: HttpClient")
+	client := strOrDef(h.Client, types.DefaultHttpClientName)
+	if svc := ctx.Ext().GetService(types.HttpClientServiceNameFor(client)); svc == nil {
+		return fmt.Errorf("no such HTTP client: '%s'", client)
 	} else {
 		hcs = svc.(types.HttpClientService)
 	}
 
-	if req, err = http.NewRequest(m, url, nil); err != nil {
+	if body, ct, err = h.requestBody(ctx); err != nil {
+		return err
+	}
+
+	if req, err = http.NewRequest(m, url, body); err != nil {
 		return err
 	}
 
@@ -118,18 +253,30 @@ func (h *httpFetchOp) Do(ctx pipeline.ActionContext) error {
 		req.Header.Set(k, ctx.TemplateEngine().RenderLenient(v, ss))
 	}
 
+	if len(ct) > 0 && len(req.Header.Get("Content-Type")) == 0 {
+		req.Header.Set("Content-Type", ct)
+	}
+
+	if h.Auth != nil {
+		if err = h.Auth.Apply(req); err != nil {
+			return err
+		}
+	}
+
 	if resp, err = hcs.RoundTripper().RoundTrip(req); err != nil {
 		return err
 	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
 
 	if data, err = io.ReadAll(resp.Body); err != nil {
 		return err
 	}
 
-	cachedResp = &types.ParsedHttpResponse{
-		StatusCode: resp.StatusCode,
-		Header:     resp.Header,
-		Body:       data,
+	cachedResp = types.NewParsedHttpResponse(resp, data)
+	if len(cachedResp.Url) == 0 {
+		cachedResp.Url = req.URL.String()
 	}
 	ctx.Logger().Log("status", resp.Status)
 	return h.doWithResponse(ctx, cachedResp)
@@ -138,9 +285,19 @@ func (h *httpFetchOp) Do(ctx pipeline.ActionContext) error {
 func (h *httpFetchOp) CloneWith(ctx pipeline.ActionContext) pipeline.Action {
 	ss := ctx.Snapshot()
 	return &httpFetchOp{
-		Method:  lo.ToPtr(ctx.TemplateEngine().RenderLenient(strOrDef(h.Method, http.MethodGet), ss)),
-		Url:     ctx.TemplateEngine().RenderLenient(h.Url, ss),
-		Headers: renderMapStrStr(h.Headers, ctx.TemplateEngine(), ss),
+		Method:    lo.ToPtr(ctx.TemplateEngine().RenderLenient(strOrDef(h.Method, http.MethodGet), ss)),
+		Url:       ctx.TemplateEngine().RenderLenient(h.Url, ss),
+		Headers:   renderMapStrStr(h.Headers, ctx.TemplateEngine(), ss),
+		Body:      safeCloneValOrRef(h.Body, ctx),
+		Form:      renderMapStrStr(h.Form, ctx.TemplateEngine(), ss),
+		ParseJson: h.ParseJson,
+		Parse:     h.Parse,
+		XPath:     h.XPath,
+		Selectors: h.Selectors,
+		Csv:       h.Csv,
+		Auth:      h.Auth,
+		Client:    h.Client,
+		StoreTo:   ctx.TemplateEngine().RenderLenient(h.StoreTo, ss),
 	}
 }
 
@@ -152,6 +309,13 @@ func renderMapStrStr(in map[string]string, teng te.TemplateEngine, data map[stri
 	return out
 }
 
+func safeCloneValOrRef(v *pipeline.ValOrRef, ctx pipeline.ActionContext) *pipeline.ValOrRef {
+	if v == nil {
+		return nil
+	}
+	return v.CloneWith(ctx)
+}
+
 func strOrDef(pstr *string, def string) string {
 	if pstr == nil {
 		return def
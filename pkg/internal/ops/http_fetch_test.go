@@ -0,0 +1,272 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rkosegi/universal-exporter/pkg/internal/services"
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
+	"github.com/rkosegi/yaml-toolkit/dom"
+	"github.com/samber/lo"
+)
+
+// runFetch runs http_fetch with given arguments against test server backed by handler.
+// URL of test server is available in data tree as "srv", response is stored to "r".
+func runFetch(t *testing.T, gd dom.ContainerBuilder, args map[string]any, h http.HandlerFunc) error {
+	t.Helper()
+	return runFetchWith(t, gd, args, h, map[string]types.HttpClientService{
+		types.DefaultHttpClientName: newFetchClient(t, types.DefaultHttpClientName, nil),
+	})
+}
+
+// runFetchWith is like runFetch, but with given HTTP client services, keyed by client profile name.
+func runFetchWith(t *testing.T, gd dom.ContainerBuilder, args map[string]any, h http.HandlerFunc,
+	hcs map[string]types.HttpClientService) error {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	svcs := make(map[string]pipeline.Service)
+	for name, hc := range hcs {
+		svcs[types.HttpClientServiceNameFor(name)] = hc
+	}
+	gd.AddValue("srv", dom.LeafNode(srv.URL))
+	if _, ok := args["url"]; !ok {
+		args["url"] = "{{ .srv }}"
+	}
+	args["storeTo"] = "r"
+	ex := pipeline.New(pipeline.WithData(gd),
+		pipeline.WithServices(svcs),
+		pipeline.WithExtActions(map[string]pipeline.ActionFactory{"http_fetch": NewHttpFetch()}))
+	return ex.Execute(&pipeline.ExtOpSpec{Function: "http_fetch", Args: &args})
+}
+
+// newFetchClient creates and starts HTTP client service with given name. Configuration can be adjusted by mut function.
+func newFetchClient(t *testing.T, name string, mut func(c *types.HttpClientServiceConfig)) types.HttpClientService {
+	t.Helper()
+	cfg := types.HttpClientServiceConfig{
+		Timeout:         lo.ToPtr(time.Second * 5),
+		Instrumentation: &types.InstrumentationConfigFragment{Enabled: lo.ToPtr(false)},
+		Cache: &types.CacheConfig{
+			Enabled:         lo.ToPtr(false),
+			TTL:             lo.ToPtr(time.Minute),
+			Capacity:        lo.ToPtr(10),
+			Instrumentation: &types.InstrumentationConfigFragment{Enabled: lo.ToPtr(false)},
+		},
+	}
+	if mut != nil {
+		mut(&cfg)
+	}
+	hcs := services.NewHttpClient(name, cfg, slog.New(slog.DiscardHandler), prometheus.NewRegistry())
+	if err := hcs.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = hcs.Close()
+	})
+	return hcs
+}
+
+// fetch is like runFetch, but fails test on error and returns stored response.
+func fetch(t *testing.T, args map[string]any, h http.HandlerFunc) dom.Container {
+	t.Helper()
+	gd := dom.ContainerNode()
+	if err := runFetch(t, gd, args, h); err != nil {
+		t.Fatal(err)
+	}
+	return gd.Child("r").AsContainer()
+}
+
+// respond creates handler that responds with given body.
+func respond(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// leafAt gets value of leaf at given path.
+func leafAt(t *testing.T, c dom.Container, path string) interface{} {
+	t.Helper()
+	n := c.Get(pp.MustParse(path))
+	if n == nil || !n.IsLeaf() {
+		t.Fatalf("no leaf at %s", path)
+	}
+	return n.AsLeaf().Value()
+}
+
+// echo responds with method, content type and body of request.
+func echo(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	_, _ = fmt.Fprintf(w, "%s|%s|%s", r.Method, r.Header.Get("Content-Type"), b)
+}
+
+func TestHttpFetchBody(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args map[string]any
+		exp  string
+	}{
+		{
+			name: "templated string",
+			args: map[string]any{"method": "POST", "body": "hello {{ .srv }}"},
+			exp:  "POST||hello http://",
+		},
+		{
+			name: "ref serialized as JSON",
+			args: map[string]any{"method": "POST", "body": map[string]any{"ref": "q"}},
+			exp:  `POST|application/json|{"size":10}`,
+		},
+		{
+			name: "form",
+			args: map[string]any{"method": "POST", "form": map[string]any{"a": "1", "b": "x y"}},
+			exp:  "POST|application/x-www-form-urlencoded|a=1&b=x+y",
+		},
+		{
+			name: "explicit content type",
+			args: map[string]any{"method": "PUT", "body": "{}", "headers": map[string]any{"Content-Type": "text/plain"}},
+			exp:  "PUT|text/plain|{}",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gd := dom.ContainerNode()
+			gd.AddContainer("q").AddValue("size", dom.LeafNode(10))
+			if err := runFetch(t, gd, tc.args, echo); err != nil {
+				t.Fatal(err)
+			}
+			if body := leafAt(t, gd, "r.body").(string); !strings.HasPrefix(body, tc.exp) {
+				t.Errorf("expected body to start with %q, got %q", tc.exp, body)
+			}
+		})
+	}
+}
+
+func TestHttpFetchBodyAndFormExclusive(t *testing.T) {
+	err := runFetch(t, dom.ContainerNode(), map[string]any{"body": "x", "form": map[string]any{"a": "b"}}, echo)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestHttpFetchResponseHeaders(t *testing.T) {
+	r := fetch(t, map[string]any{"headers": map[string]any{"X-Req": "1"}}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("x-test", "a")
+		w.Header().Add("X-Test", "b")
+		respond("ok")(w, r)
+	})
+	if status := leafAt(t, r, "status"); status != 200 {
+		t.Errorf("unexpected status %v", status)
+	}
+	if cl := leafAt(t, r, "contentLength"); cl != int64(2) {
+		t.Errorf("unexpected content length %v", cl)
+	}
+	if proto := leafAt(t, r, "proto"); proto != "HTTP/1.1" {
+		t.Errorf("unexpected proto %v", proto)
+	}
+	if !strings.HasPrefix(leafAt(t, r, "url").(string), "http://127.0.0.1") {
+		t.Errorf("unexpected url %v", leafAt(t, r, "url"))
+	}
+	vals := r.Get(pp.MustParse("headers.X-Test"))
+	if vals == nil || !vals.IsList() || vals.AsList().Size() != 2 {
+		t.Fatalf("expected 2 values of X-Test header, got %v", vals)
+	}
+	if r.Get(pp.MustParse("headers.X-Req")) != nil {
+		t.Error("request header must not be stored as response header")
+	}
+}
+
+func TestHttpFetchStale(t *testing.T) {
+	hcs := map[string]types.HttpClientService{
+		types.DefaultHttpClientName: newFetchClient(t, types.DefaultHttpClientName, func(c *types.HttpClientServiceConfig) {
+			c.RateLimit = &types.RateLimitConfig{Rate: lo.ToPtr(0.1), OnLimit: lo.ToPtr(types.OnLimitStale),
+				MaxWait: lo.ToPtr(10 * time.Millisecond)}
+		}),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+		respond("ok")(w, r)
+	}))
+	defer srv.Close()
+	// first response comes from server, second one is last successful response served on limit
+	for _, exp := range []bool{false, true} {
+		gd := dom.ContainerNode()
+		if err := runFetchWith(t, gd, map[string]any{"url": srv.URL}, respond(""), hcs); err != nil {
+			t.Fatal(err)
+		}
+		if v := leafAt(t, gd, "r.stale"); v != exp {
+			t.Errorf("expected stale to be %v, got %v", exp, v)
+		}
+		if gd.Get(pp.MustParse("r.headers."+types.StaleHeader)) != nil {
+			t.Error("internal stale header must not be stored as response header")
+		}
+		if gd.Get(pp.MustParse("r.headers.Warning")) == nil {
+			t.Error("expected Warning header of server to be stored")
+		}
+	}
+}
+
+func TestHttpFetchAuthOverride(t *testing.T) {
+	r := fetch(t, map[string]any{"auth": map[string]any{"bearer": map[string]any{"token": "abc"}}},
+		func(w http.ResponseWriter, r *http.Request) {
+			respond(r.Header.Get("Authorization"))(w, r)
+		})
+	if v := leafAt(t, r, "body"); v != "Bearer abc" {
+		t.Errorf("unexpected authorization %v", v)
+	}
+}
+
+func TestHttpFetchClientProfile(t *testing.T) {
+	bearer := func(token string) func(c *types.HttpClientServiceConfig) {
+		return func(c *types.HttpClientServiceConfig) {
+			c.Auth = &types.AuthConfig{Bearer: &types.BearerAuthConfig{Token: lo.ToPtr(token)}}
+		}
+	}
+	hcs := map[string]types.HttpClientService{
+		types.DefaultHttpClientName: newFetchClient(t, types.DefaultHttpClientName, bearer("default")),
+		"a":                         newFetchClient(t, "a", bearer("a")),
+		"b":                         newFetchClient(t, "b", bearer("b")),
+	}
+	authEcho := func(w http.ResponseWriter, r *http.Request) {
+		respond(r.Header.Get("Authorization"))(w, r)
+	}
+	for client, exp := range map[string]string{"": "Bearer default", "default": "Bearer default", "a": "Bearer a", "b": "Bearer b"} {
+		args := map[string]any{}
+		if len(client) > 0 {
+			args["client"] = client
+		}
+		gd := dom.ContainerNode()
+		if err := runFetchWith(t, gd, args, authEcho, hcs); err != nil {
+			t.Fatal(err)
+		}
+		if v := leafAt(t, gd, "r.body"); v != exp {
+			t.Errorf("client %q: expected request to go through profile with %q, got %q", client, exp, v)
+		}
+	}
+
+	err := runFetchWith(t, dom.ContainerNode(), map[string]any{"client": "c"}, authEcho, hcs)
+	if err == nil || !strings.Contains(err.Error(), "no such HTTP client: 'c'") {
+		t.Errorf("expected error for unknown client, got %v", err)
+	}
+}
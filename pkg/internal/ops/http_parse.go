@@ -0,0 +1,537 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
+	"github.com/rkosegi/yaml-toolkit/dom"
+	"github.com/samber/lo"
+	"golang.org/x/net/html"
+)
+
+// parseMode defines how response body is parsed into data tree.
+// Result of parsing is stored in child node named after the mode.
+type parseMode string
+
+const (
+	parseModeJson   parseMode = "json"
+	parseModeXml    parseMode = "xml"
+	parseModeHtml   parseMode = "html"
+	parseModeCsv    parseMode = "csv"
+	parseModeProm   parseMode = "prometheus"
+	parseModeYaml   parseMode = "yaml"
+	parseModeNdjson parseMode = "ndjson"
+	parseModeLines  parseMode = "lines"
+	parseModeKv     parseMode = "kv"
+)
+
+// bodyParserFn parses response body and stores result into container
+type bodyParserFn func(h *httpFetchOp, data []byte, c dom.ContainerBuilder) error
+
+var (
+	bodyParsers = map[parseMode]bodyParserFn{
+		parseModeJson:   parseJsonBody,
+		parseModeXml:    parseXmlBody,
+		parseModeHtml:   parseHtmlBody,
+		parseModeCsv:    parseCsvBody,
+		parseModeProm:   parsePromBody,
+		parseModeYaml:   parseYamlBody,
+		parseModeNdjson: parseNdjsonBody,
+		parseModeLines:  parseLinesBody,
+		parseModeKv:     parseKvBody,
+	}
+
+	// matches OpenMetrics exemplar at the end of sample line
+	exemplarRe = regexp.MustCompile(`(?m)[ \t]+#[ \t]+\{.*$`)
+
+	// maps OpenMetrics types unknown to Prometheus text format to their closest counterpart
+	openMetricsTypes = map[string]string{
+		"unknown":        "untyped",
+		"info":           "gauge",
+		"stateset":       "gauge",
+		"gaugehistogram": "histogram",
+	}
+
+	// matches key-value pair with single-word key and value in plain-text line, such as "Writing: 1" or "a=b"
+	kvPairRe = regexp.MustCompile(`([\w.\-]+)[ \t]*[:=][ \t]*(\S+)`)
+
+	// matches line consisting solely of key-value pairs, such as "Reading: 0 Writing: 1 Waiting: 2"
+	kvPairsLineRe = regexp.MustCompile(`^(?:[\w.\-]+[ \t]*[:=][ \t]*\S+(?:[ \t]+|$))+$`)
+)
+
+func parseJsonBody(_ *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	jd, err := dom.DecodeReader(bytes.NewReader(data), dom.DefaultJsonDecoder)
+	if err != nil {
+		return err
+	}
+	c.AddValue(string(parseModeJson), jd)
+	return nil
+}
+
+func parseYamlBody(_ *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	yd, err := dom.DecodeReader(bytes.NewReader(data), dom.DefaultYamlDecoder)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if yd == nil {
+		yd = dom.LeafNode(nil)
+	}
+	c.AddValue(string(parseModeYaml), yd)
+	return nil
+}
+
+func parseNdjsonBody(_ *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	l := dom.ListNode()
+	for i, line := range textLines(data) {
+		jd, err := dom.DecodeReader(strings.NewReader(line), dom.DefaultJsonDecoder)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		l.Append(jd)
+	}
+	c.AddValue(string(parseModeNdjson), l)
+	return nil
+}
+
+func parseLinesBody(_ *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	l := dom.ListNode()
+	for _, line := range textLines(data) {
+		l.Append(dom.LeafNode(line))
+	}
+	c.AddValue(string(parseModeLines), l)
+	return nil
+}
+
+// parseKvBody parses lines in form of "key: value" or "key=value".
+// Line consisting solely of multiple pairs with single-word keys and values, such as "Reading: 0 Writing: 1 Waiting: 2",
+// yields pair for each key. Any other line is split on first separator, so that values such as
+// "Aug  5 2020 12:00:00" are kept intact. Lines without any separator are skipped.
+func parseKvBody(_ *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	kc := c.AddContainer(string(parseModeKv))
+	for _, line := range textLines(data) {
+		if pairs := kvPairRe.FindAllStringSubmatch(line, -1); len(pairs) > 1 && kvPairsLineRe.MatchString(line) {
+			for _, pair := range pairs {
+				kc.AddValue(pair[1], dom.LeafNode(pair[2]))
+			}
+		} else if idx := strings.IndexAny(line, ":="); idx > 0 {
+			kc.AddValue(strings.TrimSpace(line[:idx]), dom.LeafNode(strings.TrimSpace(line[idx+1:])))
+		}
+	}
+	return nil
+}
+
+// textLines splits data into trimmed, non-empty lines
+func textLines(data []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func parseXmlBody(h *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	doc, err := xmlquery.Parse(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	xc := c.AddContainer(string(parseModeXml))
+	for child := doc.FirstChild; child != nil; child = child.NextSibling {
+		convertXmlNode2Dom(xc, child)
+	}
+	if len(h.XPath) > 0 {
+		xpc := c.AddContainer("xpath")
+		for name, spec := range h.XPath {
+			n, err := spec.eval(xmlquery.CreateXPathNavigator(doc))
+			if err != nil {
+				return fmt.Errorf("xpath '%s': %w", name, err)
+			}
+			if n != nil {
+				xpc.AddValue(name, n)
+			}
+		}
+	}
+	return nil
+}
+
+func parseHtmlBody(h *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	doc, err := htmlquery.Parse(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	hc := c.AddContainer(string(parseModeHtml))
+	for name, sel := range h.Selectors {
+		var nodes []*html.Node
+		switch {
+		case sel.XPath != nil && sel.Css != nil:
+			return fmt.Errorf("selector '%s': xpath and css are mutually exclusive", name)
+		case sel.XPath != nil:
+			if nodes, err = htmlquery.QueryAll(doc, *sel.XPath); err != nil {
+				return fmt.Errorf("selector '%s': %w", name, err)
+			}
+		case sel.Css != nil:
+			var sg cascadia.SelectorGroup
+			if sg, err = cascadia.ParseGroup(*sel.Css); err != nil {
+				return fmt.Errorf("selector '%s': %w", name, err)
+			}
+			nodes = cascadia.QueryAll(doc, sg)
+		default:
+			return fmt.Errorf("selector '%s': %w", name, errors.New("either xpath or css must be set"))
+		}
+		if sel.List != nil && *sel.List {
+			l := dom.ListNode()
+			for _, n := range nodes {
+				l.Append(dom.LeafNode(sel.extract(n)))
+			}
+			hc.AddValue(name, l)
+		} else if len(nodes) > 0 {
+			hc.AddValue(name, dom.LeafNode(sel.extract(nodes[0])))
+		}
+	}
+	return nil
+}
+
+// extract gets either value of attribute or text content of node.
+func (s *htmlSelectorSpec) extract(n *html.Node) string {
+	if s.Attr != nil {
+		return htmlquery.SelectAttr(n, *s.Attr)
+	}
+	return strings.TrimSpace(htmlquery.InnerText(n))
+}
+
+func singleRune(in *string, def rune) (rune, error) {
+	if in == nil {
+		return def, nil
+	}
+	if utf8.RuneCountInString(*in) != 1 {
+		return 0, fmt.Errorf("expected single character, got '%s'", *in)
+	}
+	r, _ := utf8.DecodeRuneInString(*in)
+	return r, nil
+}
+
+func parseCsvBody(h *httpFetchOp, data []byte, c dom.ContainerBuilder) (err error) {
+	spec := h.Csv
+	if spec == nil {
+		spec = &csvParseSpec{}
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	if r.Comma, err = singleRune(spec.Delimiter, ','); err != nil {
+		return err
+	}
+	if r.Comment, err = singleRune(spec.Comment, 0); err != nil {
+		return err
+	}
+	var header []string
+	if spec.Header == nil || *spec.Header {
+		if header, err = r.Read(); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	rows := dom.ListNode()
+	for {
+		var rec []string
+		if rec, err = r.Read(); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		row := dom.ContainerNode()
+		for i, val := range rec {
+			col := fmt.Sprintf("col%d", i)
+			if i < len(header) {
+				col = header[i]
+			}
+			var n dom.Node
+			if n, err = spec.toValue(col, val); err != nil {
+				line, _ := r.FieldPos(i)
+				return fmt.Errorf("line %d, column '%s': %w", line, col, err)
+			}
+			row.AddValue(col, n)
+		}
+		rows.Append(row)
+	}
+	c.AddValue(string(parseModeCsv), rows)
+	return nil
+}
+
+// toValue converts value of CSV field into leaf, using type hint for given column.
+func (s *csvParseSpec) toValue(col, val string) (dom.Node, error) {
+	switch s.Types[col] {
+	case "", "string":
+		return dom.LeafNode(val), nil
+	case "int":
+		v, err := strconv.Atoi(strings.TrimSpace(val))
+		return dom.LeafNode(v), err
+	case "float":
+		v, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return dom.LeafNode(v), err
+	case "bool":
+		v, err := strconv.ParseBool(strings.TrimSpace(val))
+		return dom.LeafNode(v), err
+	default:
+		return nil, fmt.Errorf("unknown type hint: %s", s.Types[col])
+	}
+}
+
+func parsePromBody(_ *httpFetchOp, data []byte, c dom.ContainerBuilder) error {
+	tp := expfmt.NewTextParser(model.UTF8Validation)
+	mfs, err := tp.TextToMetricFamilies(strings.NewReader(normalizeOpenMetrics(data)))
+	if err != nil {
+		return err
+	}
+	pc := c.AddContainer(string(parseModeProm))
+	for name, mf := range mfs {
+		fc := pc.AddContainer(name)
+		fc.AddValue("help", dom.LeafNode(mf.GetHelp()))
+		fc.AddValue("type", dom.LeafNode(strings.ToLower(mf.GetType().String())))
+		sl := dom.ListNode()
+		for _, m := range mf.GetMetric() {
+			sl.Append(promSample2Dom(mf.GetType(), m))
+		}
+		fc.AddValue("samples", sl)
+	}
+	return nil
+}
+
+// normalizeOpenMetrics rewrites OpenMetrics specifics, which are not understood by Prometheus text parser:
+//   - exemplars are stripped
+//   - types unknown, info, stateset and gaugehistogram are mapped to untyped, gauge, gauge and histogram
+//   - counter and info families are renamed after their samples, i.e. with "_total" and "_info" suffix
+//   - "_gcount" and "_gsum" samples of gauge histogram are renamed to "_count" and "_sum"
+//   - "_created" samples of counters, summaries and histograms are dropped
+//   - timestamps are converted from seconds to milliseconds, if payload is terminated by "# EOF"
+//
+// Prometheus text format passes through unchanged.
+func normalizeOpenMetrics(data []byte) string {
+	lines := strings.Split(string(exemplarRe.ReplaceAll(data, nil)), "\n")
+	types := make(map[string]string)
+	samples := make(map[string]bool)
+	isOm := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "# EOF" {
+			isOm = true
+		} else if f := strings.Fields(line); len(f) == 4 && f[0] == "#" && f[1] == "TYPE" {
+			types[f[2]] = f[3]
+		} else if len(line) > 0 && line[0] != '#' {
+			samples[promSampleName(line)] = true
+		}
+	}
+	renames := make(map[string]string)
+	for name, typ := range types {
+		for t, suffix := range map[string]string{"counter": "_total", "info": "_info"} {
+			if typ == t && !samples[name] && samples[name+suffix] {
+				renames[name] = name + suffix
+			}
+		}
+	}
+	var sb strings.Builder
+	for _, line := range lines {
+		if f := strings.SplitN(strings.TrimSpace(line), " ", 4); len(f) >= 3 && f[0] == "#" {
+			if newName, ok := renames[f[2]]; ok && (f[1] == "TYPE" || f[1] == "HELP") {
+				f[2] = newName
+			}
+			if typ, ok := openMetricsTypes[lo.NthOr(f, 3, "")]; ok && f[1] == "TYPE" {
+				f[3] = typ
+			}
+			line = strings.Join(f, " ")
+		} else if name := promSampleName(line); len(name) > 0 {
+			if base, ok := strings.CutSuffix(name, "_created"); ok && len(types[name]) == 0 {
+				if typ := types[base]; typ == "counter" || typ == "summary" || typ == "histogram" || typ == "gaugehistogram" {
+					continue
+				}
+			}
+			for from, to := range map[string]string{"_gcount": "_count", "_gsum": "_sum"} {
+				if base, ok := strings.CutSuffix(name, from); ok && types[base] == "gaugehistogram" {
+					line = base + to + line[len(name):]
+				}
+			}
+			if isOm {
+				line = promSecondsToMillis(line)
+			}
+		}
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// promSampleName gets metric name of sample line
+func promSampleName(line string) string {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return ""
+	}
+	if idx := strings.IndexAny(line, "{ \t"); idx >= 0 {
+		return line[:idx]
+	}
+	return line
+}
+
+// promSecondsToMillis converts timestamp of OpenMetrics sample line, given in seconds, to milliseconds.
+func promSecondsToMillis(line string) string {
+	line = strings.TrimSpace(line)
+	end := promLabelsEnd(line)
+	if end == 0 {
+		end = len(promSampleName(line))
+	}
+	f := strings.Fields(line[end:])
+	if len(f) != 2 {
+		return line
+	}
+	secs, err := strconv.ParseFloat(f[1], 64)
+	if err != nil {
+		return line
+	}
+	return strings.TrimSuffix(line, f[1]) + strconv.FormatInt(int64(math.Round(secs*1000)), 10)
+}
+
+// promLabelsEnd gets index just after closing brace of labels in sample line, or 0 if sample has no labels.
+// Quoted label values may contain braces, so they are skipped.
+func promLabelsEnd(line string) int {
+	start := strings.IndexByte(line, '{')
+	if start < 0 {
+		return 0
+	}
+	quoted := false
+	for i := start; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && quoted:
+			i++
+		case line[i] == '"':
+			quoted = !quoted
+		case line[i] == '}' && !quoted:
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// promSample2Dom converts single sample into DOM.
+// Summary and histogram samples have their quantiles and buckets keyed by quantile/upper bound.
+func promSample2Dom(mt dto.MetricType, m *dto.Metric) dom.Node {
+	sc := dom.ContainerNode()
+	lc := sc.AddContainer("labels")
+	for _, lp := range m.GetLabel() {
+		lc.AddValue(lp.GetName(), dom.LeafNode(lp.GetValue()))
+	}
+	if m.TimestampMs != nil {
+		sc.AddValue("timestamp", dom.LeafNode(m.GetTimestampMs()))
+	}
+	switch mt {
+	case dto.MetricType_COUNTER:
+		sc.AddValue("value", dom.LeafNode(m.GetCounter().GetValue()))
+	case dto.MetricType_GAUGE:
+		sc.AddValue("value", dom.LeafNode(m.GetGauge().GetValue()))
+	case dto.MetricType_SUMMARY:
+		sc.AddValue("count", dom.LeafNode(m.GetSummary().GetSampleCount()))
+		sc.AddValue("sum", dom.LeafNode(m.GetSummary().GetSampleSum()))
+		qc := sc.AddContainer("quantiles")
+		for _, q := range m.GetSummary().GetQuantile() {
+			qc.AddValue(model.FloatString(q.GetQuantile()).String(), dom.LeafNode(q.GetValue()))
+		}
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		sc.AddValue("count", dom.LeafNode(m.GetHistogram().GetSampleCount()))
+		sc.AddValue("sum", dom.LeafNode(m.GetHistogram().GetSampleSum()))
+		bc := sc.AddContainer("buckets")
+		for _, b := range m.GetHistogram().GetBucket() {
+			bc.AddValue(model.FloatString(b.GetUpperBound()).String(), dom.LeafNode(b.GetCumulativeCount()))
+		}
+	default:
+		sc.AddValue("value", dom.LeafNode(m.GetUntyped().GetValue()))
+	}
+	return sc
+}
+
+// convertXmlNode2Dom converts XML node into DOM, using same layout as pipeline's html2dom.
+// Attributes are stored in child container, text is stored in leaf and repeated elements are turned into list.
+func convertXmlNode2Dom(cb dom.ContainerBuilder, node *xmlquery.Node) {
+	switch node.Type {
+	case xmlquery.ElementNode:
+		c := dom.ContainerNode()
+		if existing := cb.Child(node.Data); existing != nil {
+			if existing.IsList() {
+				existing.(dom.ListBuilder).Append(c)
+			} else {
+				cb.AddValue(node.Data, dom.ListNode(existing, c))
+			}
+		} else {
+			cb.AddValue(node.Data, c)
+		}
+		if len(node.Attr) > 0 {
+			ac := c.AddContainer(pipeline.AttributeNode)
+			for _, attr := range node.Attr {
+				ac.AddValue(attr.Name.Local, dom.LeafNode(attr.Value))
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			convertXmlNode2Dom(c, child)
+		}
+
+	case xmlquery.TextNode, xmlquery.CharDataNode:
+		if val := strings.TrimSpace(node.Data); val != "" {
+			cb.AddValue(pipeline.ValueNode, dom.LeafNode(val))
+		}
+	}
+}
+
+// eval evaluates XPath expression against navigator.
+// Node-set results in list of leaves when List flag is set, otherwise in leaf of first node, or nil if there is none.
+func (s *xpathSpec) eval(nav xpath.NodeNavigator) (dom.Node, error) {
+	e, err := xpath.Compile(s.Expr)
+	if err != nil {
+		return nil, err
+	}
+	switch v := e.Evaluate(nav).(type) {
+	case *xpath.NodeIterator:
+		if s.List != nil && *s.List {
+			l := dom.ListNode()
+			for v.MoveNext() {
+				l.Append(dom.LeafNode(strings.TrimSpace(v.Current().Value())))
+			}
+			return l, nil
+		}
+		if v.MoveNext() {
+			return dom.LeafNode(strings.TrimSpace(v.Current().Value())), nil
+		}
+		return nil, nil
+	default:
+		return dom.LeafNode(v), nil
+	}
+}
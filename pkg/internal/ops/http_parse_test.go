@@ -0,0 +1,333 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"testing"
+
+	"github.com/rkosegi/yaml-toolkit/dom"
+)
+
+// listAt gets values of leaves in list at given path.
+func listAt(t *testing.T, c dom.Container, path string) []interface{} {
+	t.Helper()
+	n := c.Get(pp.MustParse(path))
+	if n == nil || !n.IsList() {
+		t.Fatalf("no list at %s", path)
+	}
+	var out []interface{}
+	for _, item := range n.AsList().Items() {
+		out = append(out, item.AsLeaf().Value())
+	}
+	return out
+}
+
+func TestParseXml(t *testing.T) {
+	r := fetch(t, map[string]any{"parse": "xml", "xpath": map[string]any{
+		"t":     map[string]any{"expr": "//item[@id='2']/temp"},
+		"all":   map[string]any{"expr": "//temp", "list": true},
+		"one":   map[string]any{"expr": "//name", "list": true},
+		"none":  map[string]any{"expr": "//missing", "list": true},
+		"first": map[string]any{"expr": "//temp"},
+		"cnt":   map[string]any{"expr": "count(//item)"},
+	}}, respond(`<?xml version="1.0"?>
+<root>
+  <item id="1"><temp>1.5</temp></item>
+  <item id="2"><temp>2.5</temp></item>
+  <name a="b"> hello </name>
+</root>`))
+	if v := leafAt(t, r, "xml.root.item[1].temp.Value"); v != "2.5" {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "xml.root.name.Attrs.a"); v != "b" {
+		t.Errorf("unexpected attribute %v", v)
+	}
+	if v := leafAt(t, r, "xpath.t"); v != "2.5" {
+		t.Errorf("unexpected xpath value %v", v)
+	}
+	if v := leafAt(t, r, "xpath.first"); v != "1.5" {
+		t.Errorf("unexpected xpath value %v", v)
+	}
+	if v := leafAt(t, r, "xpath.cnt"); v != float64(2) {
+		t.Errorf("unexpected count %v", v)
+	}
+	if v := listAt(t, r, "xpath.all"); len(v) != 2 || v[0] != "1.5" || v[1] != "2.5" {
+		t.Errorf("unexpected list %v", v)
+	}
+	if v := listAt(t, r, "xpath.one"); len(v) != 1 || v[0] != "hello" {
+		t.Errorf("unexpected list %v", v)
+	}
+	if v := listAt(t, r, "xpath.none"); len(v) != 0 {
+		t.Errorf("unexpected list %v", v)
+	}
+}
+
+func TestParseHtml(t *testing.T) {
+	r := fetch(t, map[string]any{"parse": "html", "selectors": map[string]any{
+		"t":     map[string]any{"css": "span#temp"},
+		"links": map[string]any{"css": "a", "attr": "href", "list": true},
+		"cells": map[string]any{"xpath": "//td[@class='v']", "list": true},
+		"href":  map[string]any{"xpath": "//a", "attr": "href"},
+		"none":  map[string]any{"css": "p.missing"},
+	}}, respond(`<html><body>
+<span id="temp"> 21.5 </span>
+<a href="/a">A</a><a href="/b">B</a>
+<table><tr><td class="v">1</td><td class="v">2</td></tr></table>
+</body></html>`))
+	if v := leafAt(t, r, "html.t"); v != "21.5" {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "html.href"); v != "/a" {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := listAt(t, r, "html.links"); len(v) != 2 || v[1] != "/b" {
+		t.Errorf("unexpected list %v", v)
+	}
+	if v := listAt(t, r, "html.cells"); len(v) != 2 || v[0] != "1" {
+		t.Errorf("unexpected list %v", v)
+	}
+	if r.Get(pp.MustParse("html.none")) != nil {
+		t.Error("selector without match must not produce value")
+	}
+}
+
+func TestParseHtmlInvalidSelector(t *testing.T) {
+	for _, sel := range []map[string]any{
+		{"css": "a", "xpath": "//a"},
+		{},
+		{"css": "[[["},
+	} {
+		if err := runFetch(t, dom.ContainerNode(), map[string]any{"parse": "html",
+			"selectors": map[string]any{"x": sel}}, respond("<html></html>")); err == nil {
+			t.Errorf("expected error for selector %v", sel)
+		}
+	}
+}
+
+func TestParseCsv(t *testing.T) {
+	r := fetch(t, map[string]any{"parse": "csv", "csv": map[string]any{
+		"comment": "#",
+		"types":   map[string]any{"val": "float", "n": "int", "ok": "bool"},
+	}}, respond("name,val,n,ok\n# comment\na, 1.5,3,true\nb,2\n"))
+	if v := leafAt(t, r, "csv[0].name"); v != "a" {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "csv[0].val"); v != 1.5 {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "csv[0].n"); v != 3 {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "csv[0].ok"); v != true {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "csv[1].val"); v != float64(2) {
+		t.Errorf("unexpected value %v", v)
+	}
+	if r.Get(pp.MustParse("csv[1].n")) != nil {
+		t.Error("missing field must not produce value")
+	}
+}
+
+func TestParseTsvWithoutHeader(t *testing.T) {
+	r := fetch(t, map[string]any{"parse": "csv", "csv": map[string]any{"delimiter": "\t", "header": false}},
+		respond("a\t1\nb\t2\n"))
+	if v := leafAt(t, r, "csv[1].col0"); v != "b" {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "csv[1].col1"); v != "2" {
+		t.Errorf("unexpected value %v", v)
+	}
+}
+
+func TestParseCsvInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		csv  map[string]any
+		body string
+	}{
+		{csv: map[string]any{"types": map[string]any{"v": "float"}}, body: "v\nabc\n"},
+		{csv: map[string]any{"types": map[string]any{"v": "date"}}, body: "v\n1\n"},
+		{csv: map[string]any{"delimiter": ";;"}, body: "v\n1\n"},
+	} {
+		if err := runFetch(t, dom.ContainerNode(), map[string]any{"parse": "csv", "csv": tc.csv},
+			respond(tc.body)); err == nil {
+			t.Errorf("expected error for %v", tc.csv)
+		}
+	}
+}
+
+func TestParseProm(t *testing.T) {
+	r := fetch(t, map[string]any{"parse": "prometheus"}, respond(`# HELP up Up
+# TYPE up gauge
+up{job="a"} 1
+up{job="b"} 0 1700000000000
+# TYPE req_total counter
+req_total 5
+# TYPE lat histogram
+lat_bucket{le="0.1"} 1
+lat_bucket{le="+Inf"} 3
+lat_sum 1.5
+lat_count 3
+# TYPE s summary
+s{quantile="0.5"} 2
+s_sum 4
+s_count 2
+`))
+	if v := leafAt(t, r, "prometheus.up.help"); v != "Up" {
+		t.Errorf("unexpected help %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.up.samples[1].timestamp"); v != int64(1700000000000) {
+		t.Errorf("unexpected timestamp %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.up.samples[0].labels.job"); v != "a" {
+		t.Errorf("unexpected label %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.req_total.type"); v != "counter" {
+		t.Errorf("unexpected type %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.req_total.samples[0].value"); v != float64(5) {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.lat.samples[0].buckets.0\\.1"); v != uint64(1) {
+		t.Errorf("unexpected bucket %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.lat.samples[0].count"); v != uint64(3) {
+		t.Errorf("unexpected count %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.s.samples[0].quantiles.0\\.5"); v != float64(2) {
+		t.Errorf("unexpected quantile %v", v)
+	}
+}
+
+func TestParseOpenMetrics(t *testing.T) {
+	r := fetch(t, map[string]any{"parse": "prometheus"}, respond(`# TYPE req counter
+# HELP req Requests
+req_total{path="/a {x}"} 5 1700000000.5 # {trace_id="x"} 1
+req_created{path="/a {x}"} 1600000000
+# TYPE bar unknown
+bar 1
+# TYPE build info
+build_info{version="1.2"} 1
+# TYPE st stateset
+st{st="a"} 1
+st{st="b"} 0
+# TYPE q gaugehistogram
+q_bucket{le="1"} 2
+q_bucket{le="+Inf"} 3
+q_gcount 3
+q_gsum 4
+# EOF
+`))
+	if v := leafAt(t, r, "prometheus.req_total.type"); v != "counter" {
+		t.Errorf("unexpected type %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.req_total.help"); v != "Requests" {
+		t.Errorf("unexpected help %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.req_total.samples[0].timestamp"); v != int64(1700000000500) {
+		t.Errorf("unexpected timestamp %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.req_total.samples[0].labels.path"); v != "/a {x}" {
+		t.Errorf("unexpected label %v", v)
+	}
+	if r.Get(pp.MustParse("prometheus.req_created")) != nil {
+		t.Error("_created sample must be dropped")
+	}
+	if v := leafAt(t, r, "prometheus.bar.type"); v != "untyped" {
+		t.Errorf("unexpected type %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.build_info.type"); v != "gauge" {
+		t.Errorf("unexpected type %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.st.samples[1].labels.st"); v != "b" {
+		t.Errorf("unexpected label %v", v)
+	}
+	if v := leafAt(t, r, "prometheus.q.samples[0].sum"); v != float64(4) {
+		t.Errorf("unexpected sum %v", v)
+	}
+}
+
+func TestParseText(t *testing.T) {
+	r := fetch(t, map[string]any{"parse": "yaml"}, respond("a:\n  b: 1\nc: [x, y]\n"))
+	if v := leafAt(t, r, "yaml.a.b"); v != 1 {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := listAt(t, r, "yaml.c"); len(v) != 2 || v[1] != "y" {
+		t.Errorf("unexpected list %v", v)
+	}
+	r = fetch(t, map[string]any{"parse": "ndjson"}, respond("{\"a\":1}\n\n{\"a\":2}\n"))
+	if v := leafAt(t, r, "ndjson[1].a"); v != float64(2) {
+		t.Errorf("unexpected value %v", v)
+	}
+	r = fetch(t, map[string]any{"parse": "lines"}, respond(" first \n\nsecond\n"))
+	if v := listAt(t, r, "lines"); len(v) != 2 || v[0] != "first" || v[1] != "second" {
+		t.Errorf("unexpected lines %v", v)
+	}
+	if err := runFetch(t, dom.ContainerNode(), map[string]any{"parse": "ndjson"}, respond("{}\n{")); err == nil {
+		t.Error("expected error for invalid line")
+	}
+}
+
+func TestParseKv(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		exp  map[string]string
+	}{
+		{
+			name: "nginx stub_status",
+			body: "Active connections: 2 \nserver accepts handled requests\n 10 10 20 \nReading: 0 Writing: 1 Waiting: 1 \n",
+			exp:  map[string]string{"Active connections": "2", "Reading": "0", "Writing": "1", "Waiting": "1"},
+		},
+		{
+			name: "apache server-status",
+			body: `ServerVersion: Apache/2.4.41 (Ubuntu)
+Server Built: Aug  5 2020 12:00:00
+CurrentTime: Thursday, 08-Oct-2020 10:27:31 UTC
+Total Accesses: 123
+CPULoad: .0123
+Scoreboard: __W_..
+`,
+			exp: map[string]string{
+				"ServerVersion":  "Apache/2.4.41 (Ubuntu)",
+				"Server Built":   "Aug  5 2020 12:00:00",
+				"CurrentTime":    "Thursday, 08-Oct-2020 10:27:31 UTC",
+				"Total Accesses": "123",
+				"CPULoad":        ".0123",
+				"Scoreboard":     "__W_..",
+			},
+		},
+		{
+			name: "equal sign",
+			body: "a=b\nx=1 y=2\nurl=http://host/?q=1\n",
+			exp:  map[string]string{"a": "b", "x": "1", "y": "2", "url": "http://host/?q=1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := fetch(t, map[string]any{"parse": "kv"}, respond(tc.body))
+			kv := r.Child("kv").AsContainer()
+			if len(kv.Children()) != len(tc.exp) {
+				t.Errorf("expected %d keys, got %v", len(tc.exp), kv.AsAny())
+			}
+			for k, v := range tc.exp {
+				if n := kv.Child(k); n == nil || n.AsLeaf().Value() != v {
+					t.Errorf("expected %s=%q, got %v", k, v, n)
+				}
+			}
+		})
+	}
+}
@@ -19,11 +19,16 @@ package ops
 import (
 	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rkosegi/universal-exporter/pkg/types"
 	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
+	"github.com/samber/lo"
 )
 
 type (
@@ -31,16 +36,113 @@ type (
 		Ref    string   `yaml:"ref"`
 		Labels []string `yaml:"labels"`
 	}
+	// timestampSpec is source timestamp of sample
+	timestampSpec struct {
+		// Timestamp is time at which sample was observed by source.
+		// When omitted, sample is exported with scrape time.
+		Timestamp *pipeline.ValOrRef `yaml:"timestamp,omitempty"`
+		// TimestampFormat is one of "unix" (seconds), "unixMilli", "rfc3339" or Go time layout.
+		// When omitted, numeric timestamp is treated as unix seconds and any other as RFC3339.
+		TimestampFormat *string `yaml:"timestampFormat,omitempty"`
+	}
 	promCounterVecOp struct {
 		commonMetricOpSpec `yaml:",inline"`
+		timestampSpec      `yaml:",inline"`
 		IncBy              *pipeline.ValOrRef `yaml:"incBy,omitempty"`
+		// Value is absolute value of upstream counter, used with counters in absolute mode.
+		Value *pipeline.ValOrRef `yaml:"value,omitempty"`
 	}
 	promGaugeVecOp struct {
 		commonMetricOpSpec `yaml:",inline"`
+		timestampSpec      `yaml:",inline"`
 		Value              pipeline.ValOrRef `yaml:"value"`
 	}
+	promObserveOp struct {
+		commonMetricOpSpec `yaml:",inline"`
+		// Value is observed value. When it references list, every item of list is observed.
+		Value pipeline.ValOrRef `yaml:"value"`
+		// Field is path within each list item that holds observed value, used when list items are containers.
+		Field *string `yaml:"field,omitempty"`
+	}
+	promInfoOp struct {
+		commonMetricOpSpec `yaml:",inline"`
+	}
+	promStateSetOp struct {
+		commonMetricOpSpec `yaml:",inline"`
+		// Value is current state, must be one of states declared by metric.
+		Value pipeline.ValOrRef `yaml:"value"`
+	}
 )
 
+// resolve resolves timestamp. Zero time is returned when timestamp is not set.
+func (t *timestampSpec) resolve(ctx pipeline.ActionContext) (time.Time, error) {
+	if t.Timestamp == nil {
+		return time.Time{}, nil
+	}
+	val := strings.TrimSpace(t.Timestamp.Resolve(ctx))
+	switch format := lo.FromPtr(t.TimestampFormat); format {
+	case "":
+		if secs, err := strconv.ParseFloat(val, 64); err == nil {
+			return unixFloat(secs, time.Second)
+		}
+		return time.Parse(time.RFC3339, val)
+	case "unix":
+		secs, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return unixFloat(secs, time.Second)
+	case "unixMilli":
+		ms, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return unixFloat(ms, time.Millisecond)
+	case "rfc3339":
+		return time.Parse(time.RFC3339, val)
+	default:
+		return time.Parse(format, val)
+	}
+}
+
+// unixFloat converts unix timestamp in given unit to time.
+// Timestamps that can't be represented in nanoseconds are rejected, such as milliseconds read as seconds.
+func unixFloat(val float64, unit time.Duration) (time.Time, error) {
+	ns := val * float64(unit)
+	if math.IsNaN(ns) || ns < math.MinInt64 || ns >= math.MaxInt64 {
+		return time.Time{}, fmt.Errorf("timestamp %v is out of range, check timestampFormat", val)
+	}
+	return time.Unix(0, int64(ns)), nil
+}
+
+// metricSpec gets MetricService and spec of referenced metric, ensuring it is of one of given types.
+func metricSpec(ctx pipeline.ActionContext, ref string, typs ...string) (types.MetricService, *types.MetricOptsSpec, error) {
+	if len(ref) == 0 {
+		return nil, nil, errors.New("empty metric reference")
+	}
+	svc := ctx.Ext().GetService("MetricService")
+	if svc == nil {
+		return nil, nil, errors.New("no such service: MetricService")
+	}
+	ms := svc.(types.MetricService)
+	spec, err := ms.GetRef(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !lo.Contains(typs, *spec.Type) {
+		return nil, nil, fmt.Errorf("metric '%s' is not %s", ref, strings.Join(typs, " or "))
+	}
+	return ms, spec, nil
+}
+
+// checkLabels ensures that number of label values matches label names of metric.
+func checkLabels(spec *types.MetricOptsSpec, labels []string) error {
+	if len(labels) != len(spec.Labels) {
+		return fmt.Errorf("metric '%s' expects %d label values, got %d", spec.Name, len(spec.Labels), len(labels))
+	}
+	return nil
+}
+
 // gauge
 
 func (p *promGaugeVecOp) String() string {
@@ -48,35 +150,29 @@ func (p *promGaugeVecOp) String() string {
 }
 
 func (p *promGaugeVecOp) Do(ctx pipeline.ActionContext) error {
-	if len(p.Ref) == 0 {
-		return errors.New("empty metric reference")
-	}
-
-	var (
-		svc interface{}
-		val float64
-		ms  types.MetricService
-	)
-
-	if svc = ctx.Ext().GetService("MetricService"); svc == nil {
-		return errors.New("no such service: MetricService")
+	ms, spec, err := metricSpec(ctx, p.Ref, "gauge")
+	if err != nil {
+		return err
 	}
 
-	ms = svc.(types.MetricService)
-
-	spec, err := ms.GetRef(p.Ref)
+	val, err := strconv.ParseFloat(p.Value.Resolve(ctx), 64)
 	if err != nil {
 		return err
 	}
 
-	val, err = strconv.ParseFloat(p.Value.Resolve(ctx), 64)
+	ts, err := p.resolve(ctx)
 	if err != nil {
 		return err
 	}
 
 	labels := ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot())
+	if err = checkLabels(spec, labels); err != nil {
+		return err
+	}
 	vec := spec.MetricRef.(*prometheus.GaugeVec)
 	vec.WithLabelValues(labels...).Set(val)
+	ms.SetTimestamp(spec, labels, ts)
+	ms.Touch(spec, labels)
 	return nil
 }
 
@@ -86,7 +182,8 @@ func (p *promGaugeVecOp) CloneWith(ctx pipeline.ActionContext) pipeline.Action {
 			Ref:    ctx.TemplateEngine().RenderLenient(p.Ref, ctx.Snapshot()),
 			Labels: ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot()),
 		},
-		Value: p.Value,
+		timestampSpec: p.timestampSpec,
+		Value:         p.Value,
 	}
 }
 
@@ -97,16 +194,42 @@ func (p *promCounterVecOp) String() string {
 }
 
 func (p *promCounterVecOp) Do(ctx pipeline.ActionContext) error {
-	var (
-		svc interface{}
-		ms  types.MetricService
-		err error
-	)
-	incBy := float64(1)
-	if len(p.Ref) == 0 {
-		return errors.New("empty metric reference")
+	ms, spec, err := metricSpec(ctx, p.Ref, "counter")
+	if err != nil {
+		return err
+	}
+
+	ts, err := p.resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	labels := ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot())
+	if err = checkLabels(spec, labels); err != nil {
+		return err
 	}
 
+	if vec, ok := spec.MetricRef.(types.AbsoluteCounterVec); ok {
+		if p.Value == nil {
+			return fmt.Errorf("counter '%s' is absolute, value must be set", p.Ref)
+		}
+		val, err := strconv.ParseFloat(p.Value.Resolve(ctx), 64)
+		if err != nil {
+			return err
+		}
+		if err = vec.Set(val, labels...); err != nil {
+			return err
+		}
+		ms.SetTimestamp(spec, labels, ts)
+		ms.Touch(spec, labels)
+		return nil
+	}
+
+	if p.Value != nil {
+		return fmt.Errorf("counter '%s' is not absolute, use incBy instead of value", p.Ref)
+	}
+
+	incBy := float64(1)
 	if p.IncBy != nil {
 		incBy, err = strconv.ParseFloat(p.IncBy.Resolve(ctx), 64)
 		if err != nil {
@@ -114,30 +237,156 @@ func (p *promCounterVecOp) Do(ctx pipeline.ActionContext) error {
 		}
 	}
 
-	if svc = ctx.Ext().GetService("MetricService"); svc != nil {
-		return errors.New("no such service: MetricService")
+	vec := spec.MetricRef.(*prometheus.CounterVec)
+	vec.WithLabelValues(labels...).Add(incBy)
+	ms.SetTimestamp(spec, labels, ts)
+	ms.Touch(spec, labels)
+	return nil
+}
+
+func (p *promCounterVecOp) CloneWith(ctx pipeline.ActionContext) pipeline.Action {
+	return &promCounterVecOp{
+		commonMetricOpSpec: commonMetricOpSpec{
+			Ref:    ctx.TemplateEngine().RenderLenient(p.Ref, ctx.Snapshot()),
+			Labels: ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot()),
+		},
+		timestampSpec: p.timestampSpec,
+		IncBy:         p.IncBy,
+		Value:         p.Value,
+	}
+}
+
+// observe
+
+func (p *promObserveOp) String() string {
+	return fmt.Sprintf("PromObserveOp[ref=%s,value=%v]", p.Ref, p.Value)
+}
+
+// values gets all values to observe.
+func (p *promObserveOp) values(ctx pipeline.ActionContext) ([]float64, error) {
+	if len(p.Value.Ref) > 0 {
+		if n := ctx.Data().Get(pp.MustParse(p.Value.Ref)); n != nil && n.IsList() {
+			vals := make([]float64, 0, n.AsList().Size())
+			for i, item := range n.AsList().Items() {
+				if p.Field != nil && item.IsContainer() {
+					item = item.AsContainer().Get(pp.MustParse(*p.Field))
+				}
+				if item == nil || !item.IsLeaf() {
+					return nil, fmt.Errorf("item %d of %s is not a value", i, p.Value.Ref)
+				}
+				val, err := strconv.ParseFloat(fmt.Sprintf("%v", item.AsLeaf().Value()), 64)
+				if err != nil {
+					return nil, err
+				}
+				vals = append(vals, val)
+			}
+			return vals, nil
+		}
+	}
+	val, err := strconv.ParseFloat(p.Value.Resolve(ctx), 64)
+	if err != nil {
+		return nil, err
+	}
+	return []float64{val}, nil
+}
+
+func (p *promObserveOp) Do(ctx pipeline.ActionContext) error {
+	ms, spec, err := metricSpec(ctx, p.Ref, "histogram", "summary")
+	if err != nil {
+		return err
+	}
+
+	vals, err := p.values(ctx)
+	if err != nil {
+		return err
+	}
+
+	labels := ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot())
+	if err = checkLabels(spec, labels); err != nil {
+		return err
+	}
+	obs := spec.MetricRef.(prometheus.ObserverVec).WithLabelValues(labels...)
+	for _, val := range vals {
+		obs.Observe(val)
+	}
+	ms.Touch(spec, labels)
+	return nil
+}
+
+func (p *promObserveOp) CloneWith(ctx pipeline.ActionContext) pipeline.Action {
+	return &promObserveOp{
+		commonMetricOpSpec: commonMetricOpSpec{
+			Ref:    ctx.TemplateEngine().RenderLenient(p.Ref, ctx.Snapshot()),
+			Labels: ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot()),
+		},
+		Value: p.Value,
+		Field: p.Field,
 	}
+}
 
-	ms = svc.(types.MetricService)
+// info
 
-	spec, err := ms.GetRef(p.Ref)
+func (p *promInfoOp) String() string {
+	return fmt.Sprintf("PromInfoOp[ref=%s]", p.Ref)
+}
 
+func (p *promInfoOp) Do(ctx pipeline.ActionContext) error {
+	ms, spec, err := metricSpec(ctx, p.Ref, "info")
 	if err != nil {
 		return err
 	}
+	labels := ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot())
+	if err = checkLabels(spec, labels); err != nil {
+		return err
+	}
+	spec.MetricRef.(*prometheus.GaugeVec).WithLabelValues(labels...).Set(1)
+	ms.Touch(spec, labels)
+	return nil
+}
 
-	vec := spec.MetricRef.(*prometheus.CounterVec)
-	vec.WithLabelValues(p.Labels...).Add(incBy)
+func (p *promInfoOp) CloneWith(ctx pipeline.ActionContext) pipeline.Action {
+	return &promInfoOp{
+		commonMetricOpSpec: commonMetricOpSpec{
+			Ref:    ctx.TemplateEngine().RenderLenient(p.Ref, ctx.Snapshot()),
+			Labels: ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot()),
+		},
+	}
+}
+
+// stateset
+
+func (p *promStateSetOp) String() string {
+	return fmt.Sprintf("PromStateSetOp[ref=%s,value=%v]", p.Ref, p.Value)
+}
+
+func (p *promStateSetOp) Do(ctx pipeline.ActionContext) error {
+	ms, spec, err := metricSpec(ctx, p.Ref, "stateset")
+	if err != nil {
+		return err
+	}
+	state := p.Value.Resolve(ctx)
+	if !lo.Contains(spec.States, state) {
+		return fmt.Errorf("metric '%s' has no state '%s'", p.Ref, state)
+	}
+	labels := ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot())
+	if err = checkLabels(spec, labels); err != nil {
+		return err
+	}
+	vec := spec.MetricRef.(*prometheus.GaugeVec)
+	for _, s := range spec.States {
+		vec.WithLabelValues(append(slices.Clip(labels), s)...).Set(lo.Ternary(s == state, 1.0, 0.0))
+	}
+	ms.Touch(spec, labels)
 	return nil
 }
 
-func (p *promCounterVecOp) CloneWith(ctx pipeline.ActionContext) pipeline.Action {
-	return &promCounterVecOp{
+func (p *promStateSetOp) CloneWith(ctx pipeline.ActionContext) pipeline.Action {
+	return &promStateSetOp{
 		commonMetricOpSpec: commonMetricOpSpec{
 			Ref:    ctx.TemplateEngine().RenderLenient(p.Ref, ctx.Snapshot()),
 			Labels: ctx.TemplateEngine().RenderSliceLenient(p.Labels, ctx.Snapshot()),
 		},
-		IncBy: p.IncBy,
+		Value: p.Value,
 	}
 }
 
@@ -153,6 +402,24 @@ func NewPromGauge() pipeline.ActionFactory {
 	})
 }
 
+func NewPromObserve() pipeline.ActionFactory {
+	return SimpleActionFactory[promObserveOp](func() *promObserveOp {
+		return &promObserveOp{}
+	})
+}
+
+func NewPromInfo() pipeline.ActionFactory {
+	return SimpleActionFactory[promInfoOp](func() *promInfoOp {
+		return &promInfoOp{}
+	})
+}
+
+func NewPromStateSet() pipeline.ActionFactory {
+	return SimpleActionFactory[promStateSetOp](func() *promStateSetOp {
+		return &promStateSetOp{}
+	})
+}
+
 type simpleActionFactoryImpl[T any] struct {
 	fn func() *T
 }
@@ -0,0 +1,360 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rkosegi/universal-exporter/pkg/internal/services"
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
+	"github.com/rkosegi/yaml-toolkit/dom"
+	"github.com/samber/lo"
+)
+
+// metricEnv runs metric actions against MetricService.
+type metricEnv struct {
+	t   *testing.T
+	ms  types.MetricService
+	reg *prometheus.Registry
+	gd  dom.ContainerBuilder
+	// svc is service used by actions, either ms or service of current target
+	svc types.MetricService
+}
+
+// newMetricEnv creates MetricService with given metrics and executor with data tree decoded from YAML source.
+func newMetricEnv(t *testing.T, mos map[string]*types.MetricOptsSpec, data string) *metricEnv {
+	t.Helper()
+	ms := services.NewMetricService(mos, slog.New(slog.DiscardHandler))
+	if err := ms.Start(); err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(ms)
+	gd, err := dom.DecodeReader(strings.NewReader(data), dom.DefaultYamlDecoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &metricEnv{t: t, ms: ms, reg: reg, gd: gd.(dom.ContainerBuilder), svc: ms}
+}
+
+// run executes metric action with given arguments.
+func (e *metricEnv) run(fn string, args map[string]any) error {
+	ex := pipeline.New(pipeline.WithData(e.gd),
+		pipeline.WithServices(map[string]pipeline.Service{"MetricService": e.svc}),
+		pipeline.WithExtActions(map[string]pipeline.ActionFactory{
+			"prom_counter":  NewPromCounter(),
+			"prom_gauge":    NewPromGauge(),
+			"prom_info":     NewPromInfo(),
+			"prom_observe":  NewPromObserve(),
+			"prom_stateset": NewPromStateSet(),
+		}))
+	return ex.Execute(&pipeline.ExtOpSpec{Function: fn, Args: &args})
+}
+
+// mustRun is like run, but fails test on error.
+func (e *metricEnv) mustRun(fn string, args map[string]any) {
+	e.t.Helper()
+	if err := e.run(fn, args); err != nil {
+		e.t.Fatalf("%s failed: %v", fn, err)
+	}
+}
+
+// expect compares gathered metrics with given names to expected text exposition.
+func (e *metricEnv) expect(expected string, names ...string) {
+	e.t.Helper()
+	if err := testutil.GatherAndCompare(e.reg, strings.NewReader(expected), names...); err != nil {
+		e.t.Error(err)
+	}
+}
+
+func TestPromObserve(t *testing.T) {
+	e := newMetricEnv(t, map[string]*types.MetricOptsSpec{
+		"job_duration": {Help: "Job duration.", Labels: []string{"kind"}, Type: lo.ToPtr("histogram"),
+			Buckets: &types.BucketsSpec{Linear: &types.BucketsGeneratorSpec{Start: 1, Width: 1, Count: 3}}},
+		"job_size": {Help: "Job size.", Type: lo.ToPtr("summary"),
+			Objectives: []types.SummaryObjective{{Quantile: 0.5, Error: 0.05}}},
+		"job_count": {Help: "Job count."},
+	}, `
+jobs:
+  - {duration: 0.5}
+  - {duration: 2.5}
+  - {duration: 10}
+sizes: [1, 2]
+size: 3
+`)
+	e.mustRun("prom_observe", map[string]any{"ref": "job_duration", "labels": []string{"a"},
+		"value": map[string]any{"ref": "jobs"}, "field": "duration"})
+	e.mustRun("prom_observe", map[string]any{"ref": "job_duration", "labels": []string{"b"}, "value": "7"})
+	e.mustRun("prom_observe", map[string]any{"ref": "job_size", "value": map[string]any{"ref": "sizes"}})
+	e.mustRun("prom_observe", map[string]any{"ref": "job_size", "value": map[string]any{"ref": "size"}})
+	e.expect(`
+# HELP job_duration Job duration.
+# TYPE job_duration histogram
+job_duration_bucket{kind="a",le="1"} 1
+job_duration_bucket{kind="a",le="2"} 1
+job_duration_bucket{kind="a",le="3"} 2
+job_duration_bucket{kind="a",le="+Inf"} 3
+job_duration_sum{kind="a"} 13
+job_duration_count{kind="a"} 3
+job_duration_bucket{kind="b",le="1"} 0
+job_duration_bucket{kind="b",le="2"} 0
+job_duration_bucket{kind="b",le="3"} 0
+job_duration_bucket{kind="b",le="+Inf"} 1
+job_duration_sum{kind="b"} 7
+job_duration_count{kind="b"} 1
+# HELP job_size Job size.
+# TYPE job_size summary
+job_size{quantile="0.5"} 2
+job_size_sum 6
+job_size_count 3
+`, "job_duration", "job_size")
+
+	for name, args := range map[string]map[string]any{
+		"not histogram or summary": {"ref": "job_count", "value": "1"},
+		"label count mismatch":     {"ref": "job_duration", "value": "1"},
+		"not a number":             {"ref": "job_size", "value": "abc"},
+		"missing field":            {"ref": "job_duration", "labels": []string{"a"}, "value": map[string]any{"ref": "jobs"}, "field": "x"},
+	} {
+		if err := e.run("prom_observe", args); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPromInfoAndStateSet(t *testing.T) {
+	e := newMetricEnv(t, map[string]*types.MetricOptsSpec{
+		"app_info": {Help: "App info.", Labels: []string{"version", "region"}, Type: lo.ToPtr("info")},
+		"app_state": {Help: "App state.", Labels: []string{"app"}, Type: lo.ToPtr("stateset"),
+			States: []string{"RUNNING", "DEGRADED", "DOWN"}},
+		"app_phase": {Help: "App phase.", Type: lo.ToPtr("stateset"), States: []string{"A", "B"},
+			StateLabel: lo.ToPtr("phase")},
+	}, `
+app:
+  version: 1.2.3
+  state: DEGRADED
+`)
+	e.mustRun("prom_info", map[string]any{"ref": "app_info", "labels": []string{"{{ .app.version }}", "eu"}})
+	e.mustRun("prom_stateset", map[string]any{"ref": "app_state", "labels": []string{"a"},
+		"value": map[string]any{"ref": "app.state"}})
+	e.mustRun("prom_stateset", map[string]any{"ref": "app_state", "labels": []string{"b"}, "value": "DOWN"})
+	e.mustRun("prom_stateset", map[string]any{"ref": "app_state", "labels": []string{"b"}, "value": "RUNNING"})
+	e.mustRun("prom_stateset", map[string]any{"ref": "app_phase", "value": "B"})
+	e.expect(`
+# HELP app_info App info.
+# TYPE app_info gauge
+app_info{region="eu",version="1.2.3"} 1
+# HELP app_phase App phase.
+# TYPE app_phase gauge
+app_phase{phase="A"} 0
+app_phase{phase="B"} 1
+# HELP app_state App state.
+# TYPE app_state gauge
+app_state{app="a",state="DEGRADED"} 1
+app_state{app="a",state="DOWN"} 0
+app_state{app="a",state="RUNNING"} 0
+app_state{app="b",state="DEGRADED"} 0
+app_state{app="b",state="DOWN"} 0
+app_state{app="b",state="RUNNING"} 1
+`)
+
+	for name, tc := range map[string]struct {
+		fn   string
+		args map[string]any
+	}{
+		"unknown state":        {fn: "prom_stateset", args: map[string]any{"ref": "app_state", "labels": []string{"a"}, "value": "BOGUS"}},
+		"not info":             {fn: "prom_info", args: map[string]any{"ref": "app_state", "labels": []string{"a"}}},
+		"not stateset":         {fn: "prom_stateset", args: map[string]any{"ref": "app_info", "value": "RUNNING"}},
+		"label count mismatch": {fn: "prom_info", args: map[string]any{"ref": "app_info", "labels": []string{"1.2.3"}}},
+	} {
+		if err := e.run(tc.fn, tc.args); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestStateSetDefinition(t *testing.T) {
+	for name, spec := range map[string]*types.MetricOptsSpec{
+		"no states":      {Type: lo.ToPtr("stateset")},
+		"label conflict": {Type: lo.ToPtr("stateset"), States: []string{"A"}, Labels: []string{"state"}},
+	} {
+		ms := services.NewMetricService(map[string]*types.MetricOptsSpec{"s": spec}, slog.New(slog.DiscardHandler))
+		if err := ms.Start(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSeriesExpiry(t *testing.T) {
+	e := newMetricEnv(t, map[string]*types.MetricOptsSpec{
+		"vm_reset":  {Help: "Reset each scrape.", Labels: []string{"vm"}, ResetEachScrape: lo.ToPtr(true)},
+		"vm_expire": {Help: "Expires.", Labels: []string{"vm"}, ExpireAfter: lo.ToPtr(50 * time.Millisecond)},
+		"vm_state": {Help: "Reset each scrape.", Labels: []string{"vm"}, Type: lo.ToPtr("stateset"),
+			States: []string{"A", "B"}, ResetEachScrape: lo.ToPtr(true)},
+	}, `{}`)
+	scrape := func(target string, vms ...string) {
+		t.Helper()
+		e.svc = e.ms.BeginTarget(target)
+		for _, vm := range vms {
+			e.mustRun("prom_gauge", map[string]any{"ref": "vm_reset", "labels": []string{vm}, "value": "1"})
+			e.mustRun("prom_gauge", map[string]any{"ref": "vm_expire", "labels": []string{vm}, "value": "1"})
+			e.mustRun("prom_stateset", map[string]any{"ref": "vm_state", "labels": []string{vm}, "value": "A"})
+		}
+	}
+	// series expected for each of metrics
+	expect := func(reset, expire, state []string) {
+		t.Helper()
+		var sb strings.Builder
+		sb.WriteString("# HELP vm_expire Expires.\n# TYPE vm_expire gauge\n")
+		for _, vm := range expire {
+			_, _ = fmt.Fprintf(&sb, "vm_expire{vm=%q} 1\n", vm)
+		}
+		sb.WriteString("# HELP vm_reset Reset each scrape.\n# TYPE vm_reset gauge\n")
+		for _, vm := range reset {
+			_, _ = fmt.Fprintf(&sb, "vm_reset{vm=%q} 1\n", vm)
+		}
+		sb.WriteString("# HELP vm_state Reset each scrape.\n# TYPE vm_state gauge\n")
+		for _, vm := range state {
+			_, _ = fmt.Fprintf(&sb, "vm_state{state=\"A\",vm=%q} 1\nvm_state{state=\"B\",vm=%q} 0\n", vm, vm)
+		}
+		e.expect(sb.String())
+	}
+
+	scrape("t1", "a", "b")
+	scrape("t2", "c")
+	expect([]string{"a", "b", "c"}, []string{"a", "b", "c"}, []string{"a", "b", "c"})
+	// b is gone from t1, series of other targets are kept
+	scrape("t1", "a")
+	expect([]string{"a", "c"}, []string{"a", "b", "c"}, []string{"a", "c"})
+	time.Sleep(60 * time.Millisecond)
+	scrape("t2", "c")
+	expect([]string{"a", "c"}, []string{"c"}, []string{"a", "c"})
+}
+
+func TestPromCounter(t *testing.T) {
+	e := newMetricEnv(t, map[string]*types.MetricOptsSpec{
+		"jobs_total": {Help: "Incremented.", Labels: []string{"count"}, Type: lo.ToPtr("counter")},
+		"requests_total": {Help: "Upstream total.", Labels: []string{"server"}, Type: lo.ToPtr("counter"),
+			CounterMode: lo.ToPtr(types.CounterModeAbsolute)},
+	}, `
+totals: [100, 150, 20, 30]
+`)
+	e.mustRun("prom_counter", map[string]any{"ref": "jobs_total", "labels": []string{"{{ len .totals }}"}})
+	e.mustRun("prom_counter", map[string]any{"ref": "jobs_total", "labels": []string{"{{ len .totals }}"}, "incBy": "2.5"})
+	for i := range 4 {
+		e.mustRun("prom_counter", map[string]any{"ref": "requests_total", "labels": []string{"a"},
+			"value": map[string]any{"ref": fmt.Sprintf("totals[%d]", i)}})
+	}
+	e.mustRun("prom_counter", map[string]any{"ref": "requests_total", "labels": []string{"b"}, "value": "7"})
+	// upstream counter was reset after 150, so 20 and then 10 more are added
+	e.expect(`
+# HELP jobs_total Incremented.
+# TYPE jobs_total counter
+jobs_total{count="4"} 3.5
+# HELP requests_total Upstream total.
+# TYPE requests_total counter
+requests_total{server="a"} 180
+requests_total{server="b"} 7
+`)
+
+	for name, args := range map[string]map[string]any{
+		"absolute without value":  {"ref": "requests_total", "labels": []string{"a"}},
+		"negative value":          {"ref": "requests_total", "labels": []string{"a"}, "value": "-1"},
+		"value of increment mode": {"ref": "jobs_total", "labels": []string{"a"}, "value": "1"},
+		"absolute label mismatch": {"ref": "requests_total", "labels": []string{"a", "b"}, "value": "1"},
+		"increment not a number":  {"ref": "jobs_total", "labels": []string{"a"}, "incBy": "x"},
+	} {
+		if err := e.run("prom_counter", args); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	e.expect(`
+# HELP requests_total Upstream total.
+# TYPE requests_total counter
+requests_total{server="a"} 180
+requests_total{server="b"} 7
+`, "requests_total")
+
+	ms := services.NewMetricService(map[string]*types.MetricOptsSpec{
+		"c": {Type: lo.ToPtr("counter"), CounterMode: lo.ToPtr(types.CounterMode("bogus"))},
+	}, slog.New(slog.DiscardHandler))
+	if err := ms.Start(); err == nil {
+		t.Error("expected error for unknown counter mode")
+	}
+}
+
+func TestSampleTimestamps(t *testing.T) {
+	e := newMetricEnv(t, map[string]*types.MetricOptsSpec{
+		"temperature": {Help: "Temperature.", Labels: []string{"city"}},
+		"requests_total": {Help: "Upstream total.", Labels: []string{"server"}, Type: lo.ToPtr("counter"),
+			CounterMode: lo.ToPtr(types.CounterModeAbsolute)},
+	}, `
+current:
+  time: "2024-05-01T12:15"
+  unix: 1714565700
+`)
+	for city, args := range map[string]map[string]any{
+		"vie": {"value": "21.5", "timestamp": map[string]any{"ref": "current.time"}, "timestampFormat": "2006-01-02T15:04"},
+		"bts": {"value": "20", "timestamp": map[string]any{"ref": "current.unix"}},
+		"prg": {"value": "19", "timestamp": "1714565700123", "timestampFormat": "unixMilli"},
+		"nyc": {"value": "18", "timestamp": "2024-05-01T12:15:00+02:00"},
+		"lon": {"value": "17"},
+		"bud": {"value": "16", "timestamp": "1714565700"},
+	} {
+		args["ref"] = "temperature"
+		args["labels"] = []string{city}
+		e.mustRun("prom_gauge", args)
+	}
+	// sample without timestamp is exported with scrape time again
+	e.mustRun("prom_gauge", map[string]any{"ref": "temperature", "labels": []string{"bud"}, "value": "15"})
+	e.mustRun("prom_counter", map[string]any{"ref": "requests_total", "labels": []string{"a"}, "value": "5",
+		"timestamp": "1714565700.5", "timestampFormat": "unix"})
+	e.expect(`
+# HELP requests_total Upstream total.
+# TYPE requests_total counter
+requests_total{server="a"} 5 1714565700500
+# HELP temperature Temperature.
+# TYPE temperature gauge
+temperature{city="bts"} 20 1714565700000
+temperature{city="bud"} 15
+temperature{city="lon"} 17
+temperature{city="nyc"} 18 1714558500000
+temperature{city="prg"} 19 1714565700123
+temperature{city="vie"} 21.5 1714565700000
+`)
+
+	for name, args := range map[string]map[string]any{
+		"not a time":           {"timestamp": "yesterday"},
+		"milliseconds as secs": {"timestamp": "1700000000000"},
+		"out of range millis":  {"timestamp": "1e300", "timestampFormat": "unixMilli"},
+		"not a number":         {"timestamp": "NaN", "timestampFormat": "unix"},
+		"layout mismatch":      {"timestamp": "2024-05-01", "timestampFormat": "rfc3339"},
+	} {
+		args["ref"] = "temperature"
+		args["labels"] = []string{"bad"}
+		args["value"] = "1"
+		if err := e.run("prom_gauge", args); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+// redactedValue replaces values that may contain credentials.
+const redactedValue = "xxxxx"
+
+// cacheEntryView is cache entry as listed by admin endpoint.
+type cacheEntryView struct {
+	Client string `json:"client"`
+	types.CacheEntry
+	// Age is number of seconds since response was stored.
+	Age float64 `json:"age"`
+	// TTL is number of seconds until entry is removed from cache.
+	TTL float64 `json:"ttl"`
+	// Fresh is true when response is used without contacting server.
+	Fresh bool `json:"fresh"`
+}
+
+type adminHandler struct {
+	cfg *types.AdminConfig
+	hcs map[string]types.HttpClientService
+	l   *slog.Logger
+}
+
+// NewAdminHandler creates handler of admin endpoints:
+//
+//   - GET <path>/cache lists cache entries, optionally filtered by "client" and "prefix" query parameters
+//   - DELETE <path>/cache purges cache entries, optionally filtered by "client" and either "key" or "prefix"
+//     query parameters. All entries are purged when no filter is given. Unlike listing, filters match full key only,
+//     as masked key matches all entries that differ in masked values.
+//
+// Endpoints are always protected by token, so error is returned when none is configured.
+func NewAdminHandler(cfg *types.AdminConfig, hcs map[string]types.HttpClientService, l *slog.Logger) (http.Handler, error) {
+	if len(lo.FromPtr(cfg.Token)) == 0 && len(lo.FromPtr(cfg.TokenFile)) == 0 {
+		return nil, errors.New("admin endpoints require token or tokenFile")
+	}
+	a := &adminHandler{cfg: cfg, hcs: hcs, l: l}
+	path := strings.TrimSuffix(lo.FromPtrOr(cfg.Path, types.DefaultAdminPath), "/")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+path+"/cache", a.listCache)
+	mux.HandleFunc("DELETE "+path+"/cache", a.purgeCache)
+	return a.authorize(mux), nil
+}
+
+// authorize rejects requests without valid token.
+func (a *adminHandler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := a.cfg.TokenValue()
+		if err == nil && len(token) == 0 {
+			err = errors.New("token is empty")
+		}
+		if err != nil {
+			a.l.Error("unable to read admin token", "err", err)
+			http.Error(w, "unable to read admin token", http.StatusInternalServerError)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clients gets HTTP client services selected by "client" query parameter, or all of them.
+func (a *adminHandler) clients(r *http.Request) map[string]types.HttpClientService {
+	if name := r.URL.Query().Get("client"); len(name) > 0 {
+		return lo.PickByKeys(a.hcs, []string{name})
+	}
+	return a.hcs
+}
+
+func (a *adminHandler) listCache(w http.ResponseWriter, r *http.Request) {
+	var (
+		now    = time.Now()
+		prefix = r.URL.Query().Get("prefix")
+		out    = make([]cacheEntryView, 0)
+	)
+	for name, hc := range a.clients(r) {
+		for _, e := range hc.CacheEntries() {
+			if hasKeyPrefix(e.Key, prefix) {
+				e.Key = redactKey(e.Key)
+				out = append(out, cacheEntryView{
+					Client:     name,
+					CacheEntry: e,
+					Age:        now.Sub(e.StoredAt).Seconds(),
+					TTL:        e.ExpiresAt.Sub(now).Seconds(),
+					Fresh:      now.Before(e.FreshUntil),
+				})
+			}
+		}
+	}
+	slices.SortFunc(out, func(x, y cacheEntryView) int {
+		return strings.Compare(x.Client+" "+x.Key, y.Client+" "+y.Key)
+	})
+	writeJson(w, out)
+}
+
+func (a *adminHandler) purgeCache(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	match := func(string) bool { return true }
+	if key := q.Get("key"); len(key) > 0 {
+		match = func(k string) bool { return k == key }
+	} else if prefix := q.Get("prefix"); len(prefix) > 0 {
+		match = func(k string) bool { return strings.HasPrefix(k, prefix) }
+	}
+	purged := 0
+	for _, hc := range a.clients(r) {
+		purged += hc.PurgeCache(match)
+	}
+	writeJson(w, map[string]int{"purged": purged})
+}
+
+// hasKeyPrefix checks whether cache key, either as is or redacted, starts with prefix.
+func hasKeyPrefix(key, prefix string) bool {
+	return strings.HasPrefix(key, prefix) || strings.HasPrefix(redactKey(key), prefix)
+}
+
+// redactKey masks values of query parameters and password in URL of cache key, as they may contain credentials.
+func redactKey(key string) string {
+	method, rest, _ := strings.Cut(key, " ")
+	rawUrl, hash, hashed := strings.Cut(rest, " ")
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return method + " " + redactedValue
+	}
+	if len(u.RawQuery) > 0 {
+		q := u.Query()
+		for _, vals := range q {
+			for i := range vals {
+				vals[i] = redactedValue
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	key = method + " " + u.Redacted()
+	if hashed {
+		key += " " + hash
+	}
+	return key
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+const (
+	keyA1 = "GET http://a.example.com/data?token=secret1"
+	keyA2 = "GET http://a.example.com/data?token=secret2"
+	keyA3 = "GET http://a.example.com/other"
+	keyB1 = "GET http://b.example.com/data"
+)
+
+// fakeHttpClient is HttpClientService backed by set of cache keys, only cache methods are implemented.
+type fakeHttpClient struct {
+	types.HttpClientService
+	keys []string
+}
+
+func (f *fakeHttpClient) CacheEntries() []types.CacheEntry {
+	return lo.Map(f.keys, func(k string, _ int) types.CacheEntry {
+		return types.CacheEntry{Key: k, StoredAt: time.Now(), ExpiresAt: time.Now().Add(time.Minute)}
+	})
+}
+
+func (f *fakeHttpClient) PurgeCache(match func(key string) bool) int {
+	n := len(f.keys)
+	f.keys = slices.DeleteFunc(f.keys, match)
+	return n - len(f.keys)
+}
+
+// newAdmin creates admin handler with token "abc" over clients "a" and "b", along with their fake services.
+func newAdmin(t *testing.T) (http.Handler, map[string]*fakeHttpClient) {
+	t.Helper()
+	fakes := map[string]*fakeHttpClient{
+		"a": {keys: []string{keyA1, keyA2, keyA3}},
+		"b": {keys: []string{keyB1}},
+	}
+	h, err := NewAdminHandler(&types.AdminConfig{Token: lo.ToPtr("abc")},
+		lo.MapValues(fakes, func(f *fakeHttpClient, _ string) types.HttpClientService { return f }),
+		slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, fakes
+}
+
+// call sends request to admin handler with given token and decodes JSON response into out, if given.
+func call(t *testing.T, h http.Handler, method, query, token string, out any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, "/admin/cache?"+query, nil)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminToken(t *testing.T) {
+	if _, err := NewAdminHandler(&types.AdminConfig{}, nil, slog.New(slog.DiscardHandler)); err == nil {
+		t.Error("expected error when no token is configured")
+	}
+	h, _ := newAdmin(t)
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		for _, token := range []string{"", "wrong"} {
+			if code := call(t, h, method, "", token, nil); code != http.StatusUnauthorized {
+				t.Errorf("%s with token %q: expected %d, got %d", method, token, http.StatusUnauthorized, code)
+			}
+		}
+		if code := call(t, h, method, "", "abc", nil); code != http.StatusOK {
+			t.Errorf("%s with valid token: expected %d, got %d", method, http.StatusOK, code)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("def\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewAdminHandler(&types.AdminConfig{TokenFile: lo.ToPtr(file)}, nil, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(t, h, http.MethodGet, "", "def", nil); code != http.StatusOK {
+		t.Errorf("expected token to be read from file, got %d", code)
+	}
+}
+
+func TestAdminListCache(t *testing.T) {
+	h, _ := newAdmin(t)
+	masked := "GET http://a.example.com/data?token=" + redactedValue
+	for _, tc := range []struct {
+		query string
+		exp   []string
+	}{
+		{"", []string{"a " + masked, "a " + masked, "a " + keyA3, "b " + keyB1}},
+		{"client=b", []string{"b " + keyB1}},
+		{"client=c", []string{}},
+		{"prefix=" + url.QueryEscape("GET http://a.example.com/o"), []string{"a " + keyA3}},
+		{"prefix=" + url.QueryEscape(keyA1), []string{"a " + masked}},
+		{"prefix=" + url.QueryEscape(masked), []string{"a " + masked, "a " + masked}},
+		{"client=b&prefix=" + url.QueryEscape("GET http://a."), []string{}},
+	} {
+		var out []cacheEntryView
+		if code := call(t, h, http.MethodGet, tc.query, "abc", &out); code != http.StatusOK {
+			t.Fatalf("%q: unexpected status %d", tc.query, code)
+		}
+		got := lo.Map(out, func(e cacheEntryView, _ int) string { return e.Client + " " + e.Key })
+		if !slices.Equal(got, tc.exp) {
+			t.Errorf("%q: expected %v, got %v", tc.query, tc.exp, got)
+		}
+	}
+}
+
+func TestAdminPurgeCache(t *testing.T) {
+	masked := "GET http://a.example.com/data?token=" + redactedValue
+	for _, tc := range []struct {
+		query   string
+		expA    []string
+		expB    []string
+		expPurg int
+	}{
+		{"key=" + url.QueryEscape(keyA1), []string{keyA2, keyA3}, []string{keyB1}, 1},
+		// masked key must not purge entries that differ in masked values only
+		{"key=" + url.QueryEscape(masked), []string{keyA1, keyA2, keyA3}, []string{keyB1}, 0},
+		{"prefix=" + url.QueryEscape(masked), []string{keyA1, keyA2, keyA3}, []string{keyB1}, 0},
+		{"prefix=" + url.QueryEscape("GET http://a.example.com/data"), []string{keyA3}, []string{keyB1}, 2},
+		{"client=b", []string{keyA1, keyA2, keyA3}, []string{}, 1},
+		{"", []string{}, []string{}, 4},
+	} {
+		h, fakes := newAdmin(t)
+		var out map[string]int
+		if code := call(t, h, http.MethodDelete, tc.query, "abc", &out); code != http.StatusOK {
+			t.Fatalf("%q: unexpected status %d", tc.query, code)
+		}
+		if out["purged"] != tc.expPurg {
+			t.Errorf("%q: expected %d purged entries, got %d", tc.query, tc.expPurg, out["purged"])
+		}
+		if !slices.Equal(fakes["a"].keys, tc.expA) || !slices.Equal(fakes["b"].keys, tc.expB) {
+			t.Errorf("%q: expected remaining keys %v and %v, got %v and %v",
+				tc.query, tc.expA, tc.expB, fakes["a"].keys, fakes["b"].keys)
+		}
+	}
+}
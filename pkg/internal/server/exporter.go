@@ -26,6 +26,7 @@ import (
 	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
 	"github.com/rkosegi/yaml-toolkit/dom"
 	"github.com/rkosegi/yaml-toolkit/props"
+	"github.com/samber/lo"
 )
 
 var pp = props.NewPathParser()
@@ -61,13 +62,15 @@ type pipelineCollector struct {
 	up             prometheus.Gauge
 	scrapeFailures *prometheus.CounterVec
 	scrapeSum      *prometheus.SummaryVec
-	hcs            types.HttpClientService
+	hcs            map[string]types.HttpClientService
 }
 
 func (p *pipelineCollector) Describe(ch chan<- *prometheus.Desc) {
 	p.up.Describe(ch)
 	p.ms.Describe(ch)
-	p.hcs.Describe(ch)
+	for _, hcs := range p.hcs {
+		hcs.Describe(ch)
+	}
 }
 
 func applyVars(kvs map[string]string, gd dom.ContainerBuilder) {
@@ -76,28 +79,43 @@ func applyVars(kvs map[string]string, gd dom.ContainerBuilder) {
 	}
 }
 
-func (p *pipelineCollector) Collect(ch chan<- prometheus.Metric) {
-	p.up.Set(1)
-	gd := dom.ContainerNode()
-	ex := pipeline.New(
+func (p *pipelineCollector) services(ms types.MetricService) map[string]pipeline.Service {
+	svcs := map[string]pipeline.Service{
+		"MetricService": ms,
+	}
+	for name, hcs := range p.hcs {
+		svcs[types.HttpClientServiceNameFor(name)] = hcs
+	}
+	return svcs
+}
+
+// executor creates pipeline executor of single target, which uses given metric service.
+func (p *pipelineCollector) executor(gd dom.ContainerBuilder, ms types.MetricService) pipeline.Executor {
+	return pipeline.New(
 		pipeline.WithData(gd),
 		pipeline.WithListener(&pipelineLogAdapter{
 			l: p.l.With("component", "pipeline"),
 		}),
-		pipeline.WithServices(map[string]pipeline.Service{
-			"HttpClient":    p.hcs,
-			"MetricService": p.ms,
-		}),
+		pipeline.WithServices(p.services(ms)),
 		pipeline.WithExtActions(map[string]pipeline.ActionFactory{
-			"http_fetch":   ops.NewHttpFetch(),
-			"prom_counter": ops.NewPromCounter(),
-			"prom_gauge":   ops.NewPromGauge(),
+			"http_fetch":    ops.NewHttpFetch(),
+			"prom_counter":  ops.NewPromCounter(),
+			"prom_gauge":    ops.NewPromGauge(),
+			"prom_info":     ops.NewPromInfo(),
+			"prom_observe":  ops.NewPromObserve(),
+			"prom_stateset": ops.NewPromStateSet(),
 		}),
 	)
-	p.lastErr.Set(0)
+}
+
+func (p *pipelineCollector) Collect(ch chan<- prometheus.Metric) {
+	p.up.Set(1)
+	gd := dom.ContainerNode()
+	failed := false
 	for k, v := range p.gc.Targets {
 		start := time.Now()
-		p.l.Debug("Processing target", "name", k, "steps", v.Steps)
+		// only step names are logged, arguments may contain credentials
+		p.l.Debug("Processing target", "name", k, "steps", lo.Keys(v.Steps))
 		po := &pipeline.PipelineSpec{}
 		po.Children = v.Steps
 
@@ -105,24 +123,30 @@ func (p *pipelineCollector) Collect(ch chan<- prometheus.Metric) {
 		applyVars(p.gc.Vars, gd)
 		applyVars(v.Vars, gd)
 
-		err := ex.Execute(po)
+		// series touched by pipeline are owned by target through its own metric service,
+		// so pipelines of concurrent scrapes don't need to be serialized
+		err := p.executor(gd, p.ms.BeginTarget(k)).Execute(po)
 		if err != nil {
 			p.l.Error("Error executing pipeline", "name", k, "err", err)
-			p.lastErr.Set(1)
+			failed = true
 			p.scrapeFailures.WithLabelValues(k).Inc()
 		}
 		p.scrapeSum.WithLabelValues(k).Observe(time.Since(start).Seconds())
 	}
+	p.lastErr.Set(lo.Ternary(failed, 1.0, 0.0))
 
 	p.scrapeSum.Collect(ch)
 	p.lastErr.Collect(ch)
 	p.scrapeFailures.Collect(ch)
 	p.up.Collect(ch)
 	p.ms.Collect(ch)
-	p.hcs.Collect(ch)
+	for _, hcs := range p.hcs {
+		hcs.Collect(ch)
+	}
 }
 
-func NewExporter(cfg *types.Config, logger *slog.Logger, hcs types.HttpClientService, ms types.MetricService) prometheus.Collector {
+// NewExporter creates new exporter. HTTP client services are keyed by their profile name.
+func NewExporter(cfg *types.Config, logger *slog.Logger, hcs map[string]types.HttpClientService, ms types.MetricService) prometheus.Collector {
 	return &pipelineCollector{
 		gc:  cfg,
 		l:   logger,
@@ -0,0 +1,107 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rkosegi/universal-exporter/pkg/internal/services"
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
+	"github.com/samber/lo"
+)
+
+// extStep creates pipeline step that invokes ext function with given arguments.
+func extStep(order int, fn string, args map[string]any) pipeline.ActionSpec {
+	return pipeline.ActionSpec{
+		ActionMeta: pipeline.ActionMeta{Order: lo.ToPtr(order)},
+		Operations: pipeline.OpSpec{Ext: &pipeline.ExtOpSpec{Function: fn, Args: &args}},
+	}
+}
+
+func TestConcurrentScrapes(t *testing.T) {
+	// server responds once both scrapes are waiting for it, so serialized scrapes fail on timeout
+	var inFlight atomic.Int32
+	bothWaiting := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if inFlight.Add(1) == 2 {
+			close(bothWaiting)
+		}
+		select {
+		case <-bothWaiting:
+			_, _ = w.Write([]byte("1"))
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer srv.Close()
+
+	l := slog.New(slog.DiscardHandler)
+	hc := services.NewHttpClient(types.DefaultHttpClientName, types.HttpClientServiceConfig{
+		Timeout:         lo.ToPtr(5 * time.Second),
+		Instrumentation: &types.InstrumentationConfigFragment{Enabled: lo.ToPtr(false)},
+		Cache: &types.CacheConfig{Enabled: lo.ToPtr(false), TTL: lo.ToPtr(time.Minute), Capacity: lo.ToPtr(10),
+			Instrumentation: &types.InstrumentationConfigFragment{Enabled: lo.ToPtr(false)}},
+	}, l, prometheus.NewRegistry())
+	if err := hc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = hc.Close()
+	}()
+	ms := services.NewMetricService(map[string]*types.MetricOptsSpec{
+		"value": {Help: "Value.", ResetEachScrape: lo.ToPtr(true)},
+	}, l)
+	if err := ms.Start(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &types.Config{Targets: map[string]types.ScrapeTarget{
+		"t": {Steps: pipeline.ChildActions{
+			"fetch": extStep(1, "http_fetch", map[string]any{"url": srv.URL, "storeTo": "r"}),
+			"gauge": extStep(2, "prom_gauge", map[string]any{"ref": "value", "value": "{{ .r.body }}"}),
+		}},
+	}}
+	p := NewExporter(cfg, l, map[string]types.HttpClientService{types.DefaultHttpClientName: hc}, ms).(*pipelineCollector)
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			ch := make(chan prometheus.Metric)
+			go func() {
+				for range ch {
+				}
+			}()
+			p.Collect(ch)
+			close(ch)
+		})
+	}
+	wg.Wait()
+	if failures := testutil.ToFloat64(p.scrapeFailures.WithLabelValues("t")); failures != 0 {
+		t.Errorf("expected concurrent scrapes to succeed, got %v failures", failures)
+	}
+	if v := testutil.ToFloat64(p.lastErr); v != 0 {
+		t.Errorf("expected last error to be 0, got %v", v)
+	}
+}
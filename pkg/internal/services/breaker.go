@@ -0,0 +1,134 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker is circuit breaker keyed by host.
+// After threshold consecutive failures, circuit opens and requests fail fast until cool-down elapses.
+// Then single probe request is let through, which either closes circuit or opens it again.
+type breaker struct {
+	threshold int
+	coolDown  time.Duration
+	onChange  func(host string, from, to circuitState)
+	mu        sync.Mutex
+	hosts     map[string]*hostCircuit
+}
+
+type hostCircuit struct {
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(cfg *types.CircuitBreakerConfig, onChange func(string, circuitState, circuitState)) *breaker {
+	return &breaker{
+		threshold: max(lo.FromPtrOr(cfg.FailureThreshold, types.DefaultCircuitFailureThreshold), 1),
+		coolDown:  lo.FromPtrOr(cfg.CoolDown, types.DefaultCircuitCoolDown),
+		onChange:  onChange,
+		hosts:     make(map[string]*hostCircuit),
+	}
+}
+
+func (b *breaker) forHost(host string) *hostCircuit {
+	hc, ok := b.hosts[host]
+	if !ok {
+		hc = &hostCircuit{}
+		b.hosts[host] = hc
+		b.onChange(host, circuitClosed, circuitClosed)
+	}
+	return hc
+}
+
+func (b *breaker) setState(host string, hc *hostCircuit, state circuitState) {
+	if hc.state != state {
+		from := hc.state
+		hc.state = state
+		b.onChange(host, from, state)
+	}
+}
+
+// allow checks whether request to host can be sent.
+func (b *breaker) allow(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hc := b.forHost(host)
+	switch hc.state {
+	case circuitOpen:
+		if time.Since(hc.openedAt) < b.coolDown {
+			return false
+		}
+		b.setState(host, hc, circuitHalfOpen)
+		hc.probing = true
+		return true
+	case circuitHalfOpen:
+		if hc.probing {
+			return false
+		}
+		hc.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record records outcome of request to host.
+func (b *breaker) record(host string, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hc := b.forHost(host)
+	hc.probing = false
+	if success {
+		hc.failures = 0
+		b.setState(host, hc, circuitClosed)
+		return
+	}
+	hc.failures++
+	if hc.state == circuitHalfOpen || hc.failures >= b.threshold {
+		hc.openedAt = time.Now()
+		b.setState(host, hc, circuitOpen)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+// cacheStore is storage backend of HTTP response cache.
+type cacheStore interface {
+	Get(key string) *types.ParsedHttpResponse
+	// Set stores response under key. Primary key is key of request without headers listed in Vary response header.
+	Set(key, primaryKey string, r *types.ParsedHttpResponse, ttl time.Duration)
+	Delete(key string)
+	Entries() []types.CacheEntry
+	// Varies gets names of request headers that stored responses vary on, keyed by primary key.
+	// Only entries loaded on start are considered, others are tracked by client itself.
+	Varies() map[string][]string
+	Start() error
+	Close() error
+}
+
+// newCacheStore creates cache store according to configuration.
+func newCacheStore(cfg *types.CacheConfig, l *slog.Logger) (cacheStore, error) {
+	switch backend := lo.FromPtrOr(cfg.Backend, types.CacheBackendMemory); backend {
+	case types.CacheBackendMemory:
+		return newMemoryStore(cfg), nil
+	case types.CacheBackendDisk:
+		if cfg.Disk == nil || len(lo.FromPtr(cfg.Disk.Path)) == 0 {
+			return nil, fmt.Errorf("path of disk cache is not configured")
+		}
+		return newDiskStore(cfg, l), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %s", backend)
+	}
+}
+
+// memoryStore keeps cached responses in memory only.
+type memoryStore struct {
+	c *ttlcache.Cache[string, *types.ParsedHttpResponse]
+}
+
+func newMemoryStore(cfg *types.CacheConfig) *memoryStore {
+	return &memoryStore{
+		c: ttlcache.New[string, *types.ParsedHttpResponse](
+			ttlcache.WithDisableTouchOnHit[string, *types.ParsedHttpResponse](),
+			ttlcache.WithTTL[string, *types.ParsedHttpResponse](*cfg.TTL),
+			ttlcache.WithCapacity[string, *types.ParsedHttpResponse](uint64(*cfg.Capacity)),
+		),
+	}
+}
+
+func (m *memoryStore) Get(key string) *types.ParsedHttpResponse {
+	if i := m.c.Get(key); i != nil {
+		return i.Value()
+	}
+	return nil
+}
+
+func (m *memoryStore) Set(key, _ string, r *types.ParsedHttpResponse, ttl time.Duration) {
+	m.c.Set(key, r, ttl)
+}
+
+func (m *memoryStore) Delete(key string) {
+	m.c.Delete(key)
+}
+
+func (m *memoryStore) Entries() []types.CacheEntry {
+	var entries []types.CacheEntry
+	m.c.Range(func(i *ttlcache.Item[string, *types.ParsedHttpResponse]) bool {
+		if !i.IsExpired() {
+			entries = append(entries, types.CacheEntry{
+				Key:        i.Key(),
+				StoredAt:   i.Value().StoredAt,
+				FreshUntil: i.Value().FreshUntil,
+				ExpiresAt:  i.ExpiresAt(),
+				Size:       len(i.Value().Body),
+			})
+		}
+		return true
+	})
+	return entries
+}
+
+func (m *memoryStore) Varies() map[string][]string {
+	return nil
+}
+
+func (m *memoryStore) Start() error {
+	go m.c.Start()
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	m.c.Stop()
+	return nil
+}
+
+// diskEntry is cached response as persisted on disk.
+type diskEntry struct {
+	Key        string                    `json:"key"`
+	PrimaryKey string                    `json:"primaryKey,omitempty"`
+	ExpiresAt  time.Time                 `json:"expiresAt"`
+	Response   *types.ParsedHttpResponse `json:"response"`
+}
+
+// diskStore keeps cached responses in memory and persists them into directory, one file per entry,
+// so that they survive restart.
+type diskStore struct {
+	*memoryStore
+	dir      string
+	maxBytes int64
+	l        *slog.Logger
+	mu       sync.Mutex
+	files    map[string]diskFile
+	varies   map[string][]string
+}
+
+// diskFile tracks size and expiry of persisted entry.
+type diskFile struct {
+	size int64
+	exp  time.Time
+}
+
+func newDiskStore(cfg *types.CacheConfig, l *slog.Logger) *diskStore {
+	d := &diskStore{
+		memoryStore: newMemoryStore(cfg),
+		dir:         *cfg.Disk.Path,
+		maxBytes:    lo.FromPtr(cfg.Disk.MaxBytes),
+		l:           l,
+		files:       make(map[string]diskFile),
+		varies:      make(map[string][]string),
+	}
+	d.c.OnEviction(func(_ context.Context, _ ttlcache.EvictionReason, i *ttlcache.Item[string, *types.ParsedHttpResponse]) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		// entry could have been stored again in meantime
+		if !d.c.Has(i.Key()) {
+			d.remove(i.Key())
+		}
+	})
+	return d
+}
+
+func (d *diskStore) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (d *diskStore) remove(key string) {
+	delete(d.files, key)
+	if err := os.Remove(d.file(key)); err != nil && !os.IsNotExist(err) {
+		d.l.Warn("unable to remove cache file", "key", key, "err", err.Error())
+	}
+}
+
+func (d *diskStore) Set(key, primaryKey string, r *types.ParsedHttpResponse, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.memoryStore.Set(key, primaryKey, r, ttl)
+	exp := time.Now().Add(ttl)
+	data, err := json.Marshal(&diskEntry{Key: key, PrimaryKey: primaryKey, ExpiresAt: exp, Response: r})
+	if err == nil {
+		// write to temporary file first, so that partially written entry is never loaded
+		tmp := d.file(key) + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, d.file(key))
+		}
+	}
+	if err != nil {
+		d.l.Warn("unable to persist cache entry", "key", key, "err", err.Error())
+		return
+	}
+	d.files[key] = diskFile{size: int64(len(data)), exp: exp}
+	if d.maxBytes > 0 {
+		d.prune()
+	}
+}
+
+func (d *diskStore) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.memoryStore.Delete(key)
+	d.remove(key)
+}
+
+// Start loads entries that haven't expired yet from disk.
+func (d *diskStore) Start() error {
+	if err := os.MkdirAll(d.dir, 0o700); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	tmps, _ := filepath.Glob(filepath.Join(d.dir, "*.json.tmp"))
+	for _, f := range tmps {
+		_ = os.Remove(f)
+	}
+	d.mu.Lock()
+	now := time.Now()
+	loaded := 0
+	for _, f := range files {
+		var e diskEntry
+		data, err := os.ReadFile(f)
+		if err == nil {
+			err = json.Unmarshal(data, &e)
+		}
+		if err != nil || e.Response == nil || !now.Before(e.ExpiresAt) {
+			_ = os.Remove(f)
+			continue
+		}
+		d.memoryStore.Set(e.Key, e.PrimaryKey, e.Response, e.ExpiresAt.Sub(now))
+		d.files[e.Key] = diskFile{size: int64(len(data)), exp: e.ExpiresAt}
+		// variant keys can only be computed again when names of headers that response varies on are known
+		if vary, ok := responseVary(e.Response.Header); ok && len(vary) > 0 && len(e.PrimaryKey) > 0 {
+			d.varies[e.PrimaryKey] = vary
+		}
+		loaded++
+	}
+	d.mu.Unlock()
+	d.l.Info("loaded cache entries from disk", "path", d.dir, "count", loaded)
+	return d.memoryStore.Start()
+}
+
+func (d *diskStore) Varies() map[string][]string {
+	return d.varies
+}
+
+// Close prunes expired entries and entries over size limit from disk.
+func (d *diskStore) Close() error {
+	err := d.memoryStore.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune()
+	return err
+}
+
+// prune removes expired entries from disk. If total size of remaining entries exceeds maxBytes,
+// entries that expire soonest are removed until it doesn't.
+func (d *diskStore) prune() {
+	var total int64
+	now := time.Now()
+	for key, f := range d.files {
+		if !now.Before(f.exp) {
+			d.remove(key)
+			continue
+		}
+		total += f.size
+	}
+	if d.maxBytes <= 0 || total <= d.maxBytes {
+		return
+	}
+	keys := slices.SortedFunc(maps.Keys(d.files), func(a, b string) int {
+		return d.files[a].exp.Compare(d.files[b].exp)
+	})
+	for _, key := range keys {
+		if total <= d.maxBytes {
+			break
+		}
+		total -= d.files[key].size
+		d.remove(key)
+		d.memoryStore.Delete(key)
+	}
+}
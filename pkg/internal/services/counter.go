@@ -0,0 +1,99 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rkosegi/universal-exporter/pkg/types"
+)
+
+// absoluteCounterVec is vector of counters that follow upstream counters.
+// Counters of client_golang can't be set, so values are exported as const metrics on every collection.
+type absoluteCounterVec struct {
+	desc   *prometheus.Desc
+	labels int
+	mu     sync.Mutex
+	series map[string]*absoluteCounter
+}
+
+type absoluteCounter struct {
+	labels []string
+	// last is last seen upstream value
+	last float64
+	// total is exported value
+	total float64
+}
+
+func newAbsoluteCounterVec(opt *types.MetricOptsSpec) *absoluteCounterVec {
+	return &absoluteCounterVec{
+		desc:   prometheus.NewDesc(opt.Name, opt.Help, opt.Labels, opt.ConstLabels),
+		labels: len(opt.Labels),
+		series: make(map[string]*absoluteCounter),
+	}
+}
+
+func (c *absoluteCounterVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *absoluteCounterVec) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.series {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, s.total, s.labels...)
+	}
+}
+
+// Set sets counter to value of upstream counter. When upstream value decreased, upstream counter is considered reset
+// and whole new value is added to exported value, so that exported counter keeps increasing.
+func (c *absoluteCounterVec) Set(val float64, labels ...string) error {
+	if len(labels) != c.labels {
+		return fmt.Errorf("expected %d label values, got %d", c.labels, len(labels))
+	}
+	if val < 0 || math.IsNaN(val) {
+		return fmt.Errorf("counter can't be set to %v", val)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := seriesKey(labels)
+	s, ok := c.series[key]
+	switch {
+	case !ok:
+		c.series[key] = &absoluteCounter{labels: slices.Clone(labels), last: val, total: val}
+		return nil
+	case val >= s.last:
+		s.total += val - s.last
+	default:
+		s.total += val
+	}
+	s.last = val
+	return nil
+}
+
+func (c *absoluteCounterVec) DeleteLabelValues(lvs ...string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := seriesKey(lvs)
+	_, ok := c.series[key]
+	delete(c.series, key)
+	return ok
+}
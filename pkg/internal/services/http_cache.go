@@ -0,0 +1,212 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+var errBodyNotReplayable = errors.New("request body can't be read repeatedly")
+
+// heuristicallyCacheable are status codes that can be cached without explicit freshness information, see RFC 9110.
+var heuristicallyCacheable = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// parseCacheControl parses Cache-Control directives into map. Directives without value map to empty string.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if len(d) == 0 {
+				continue
+			}
+			k, v, _ := strings.Cut(d, "=")
+			cc[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return cc
+}
+
+// httpFreshness computes freshness lifetime of response according to RFC 9111.
+// Heuristic lifetime is used when response has no explicit freshness information.
+// Second return value is false when response must not be stored.
+func httpFreshness(r *types.ParsedHttpResponse, heuristic time.Duration) (time.Duration, bool) {
+	cc := parseCacheControl(r.Header)
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		// can be stored, but must be revalidated every time
+		return 0, true
+	}
+	var lifetime time.Duration
+	if v, ok := cc["max-age"]; ok {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, true
+		}
+		lifetime = time.Duration(secs) * time.Second
+	} else if v := r.Header.Get("Expires"); len(v) > 0 {
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			// invalid date represents time in the past
+			return 0, true
+		}
+		date := r.StoredAt
+		if d, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+			date = d
+		}
+		lifetime = exp.Sub(date)
+	} else {
+		if !heuristicallyCacheable[r.StatusCode] {
+			return 0, false
+		}
+		lifetime = heuristic
+	}
+	if age, err := strconv.Atoi(r.Header.Get("Age")); err == nil {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	return max(lifetime, 0), true
+}
+
+// hasValidators checks whether response can be revalidated using conditional request.
+func hasValidators(r *types.ParsedHttpResponse) bool {
+	return len(r.Header.Get("ETag")) > 0 || len(r.Header.Get("Last-Modified")) > 0
+}
+
+// withValidators creates conditional request to revalidate cached response.
+// Request is left intact when caller already made it conditional.
+func withValidators(req *http.Request, r *types.ParsedHttpResponse) (*http.Request, bool) {
+	if !hasValidators(r) || len(req.Header.Get("If-None-Match")) > 0 || len(req.Header.Get("If-Modified-Since")) > 0 {
+		return req, false
+	}
+	req = req.Clone(req.Context())
+	if etag := r.Header.Get("ETag"); len(etag) > 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lm := r.Header.Get("Last-Modified"); len(lm) > 0 {
+		req.Header.Set("If-Modified-Since", lm)
+	}
+	return req, true
+}
+
+// revalidated creates copy of cached response, updated with headers from 304 (Not Modified) response.
+func revalidated(cached *types.ParsedHttpResponse, resp *http.Response) *types.ParsedHttpResponse {
+	r := *cached
+	r.Header = cached.Header.Clone()
+	for k, v := range resp.Header {
+		if k != "Content-Length" {
+			r.Header[k] = v
+		}
+	}
+	r.StoredAt = time.Now()
+	return &r
+}
+
+// cacheKey computes key of request in cache. Key consists of method and URL, followed by hash of request body
+// and values of given request headers, if there are any. Body and header values are hashed so that credentials
+// in them don't leak into keys. URL is kept as is, so credentials in query string are part of key.
+func cacheKey(req *http.Request, headers []string) (string, error) {
+	key := req.Method + " " + req.URL.String()
+	hash := sha256.New()
+	hashed := false
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", errBodyNotReplayable
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			_ = body.Close()
+		}()
+		n, err := io.Copy(hash, body)
+		if err != nil {
+			return "", err
+		}
+		hashed = n > 0
+	}
+	headers = slices.Sorted(slices.Values(lo.Map(headers, func(name string, _ int) string {
+		return http.CanonicalHeaderKey(name)
+	})))
+	for _, name := range slices.Compact(headers) {
+		if vals := req.Header.Values(name); len(vals) > 0 {
+			_, _ = io.WriteString(hash, "\n"+name+":"+strings.Join(vals, ","))
+			hashed = true
+		}
+	}
+	if hashed {
+		key += " " + hex.EncodeToString(hash.Sum(nil))[:16]
+	}
+	return key, nil
+}
+
+// responseVary gets names of request headers listed in Vary response header.
+// Second return value is false when response varies on everything ("*") and can't be cached.
+func responseVary(h http.Header) ([]string, bool) {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, n := range strings.Split(v, ",") {
+			n = http.CanonicalHeaderKey(strings.TrimSpace(n))
+			if n == "*" {
+				return nil, false
+			}
+			if len(n) > 0 {
+				names = append(names, n)
+			}
+		}
+	}
+	return names, true
+}
+
+// forbidsStale checks whether Cache-Control directives of response forbid serving it stale.
+func forbidsStale(r *types.ParsedHttpResponse) bool {
+	cc := parseCacheControl(r.Header)
+	_, noCache := cc["no-cache"]
+	_, mustRevalidate := cc["must-revalidate"]
+	return noCache || mustRevalidate
+}
+
+// withinStaleWindow checks whether stale response can still be served within given window after it became stale.
+func withinStaleWindow(r *types.ParsedHttpResponse, window *time.Duration, now time.Time) bool {
+	if r == nil || window == nil {
+		return false
+	}
+	return now.Before(r.FreshUntil.Add(*window))
+}
+
+// staleCopy creates copy of cached response marked as stale.
+func staleCopy(r *types.ParsedHttpResponse) *types.ParsedHttpResponse {
+	s := *r
+	s.Stale = true
+	return &s
+}
@@ -19,10 +19,15 @@ package services
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
@@ -30,13 +35,19 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rkosegi/universal-exporter/pkg/types"
 	"github.com/rkosegi/yaml-toolkit/fluent"
+	"github.com/samber/lo"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	defCfg = &types.HttpClientServiceConfig{}
 )
 
+// key2host gets host from cache key, which is method followed by URL and optional hash.
 func key2host(key string) string {
+	if fields := strings.Fields(key); len(fields) > 1 {
+		key = fields[1]
+	}
 	if u, err := url.Parse(key); err == nil {
 		return u.Host
 	}
@@ -45,30 +56,98 @@ func key2host(key string) string {
 
 type hcServiceImpl struct {
 	*noopService
-	cfg         types.HttpClientServiceConfig `yaml:"config"`
-	started     bool
-	l           *slog.Logger
-	cache       *ttlcache.Cache[string, *types.ParsedHttpResponse]
-	reg         prometheus.Registerer
-	hitCounter  *prometheus.CounterVec
-	missCounter *prometheus.CounterVec
-	counter     *prometheus.CounterVec
-	histVec     *prometheus.HistogramVec
-	hc          http.Client
+	name         string
+	cfg          types.HttpClientServiceConfig `yaml:"config"`
+	started      bool
+	l            *slog.Logger
+	cache        cacheStore
+	reg          prometheus.Registerer
+	hitCounter   *prometheus.CounterVec
+	missCounter  *prometheus.CounterVec
+	revalCounter *prometheus.CounterVec
+	counter      *prometheus.CounterVec
+	histVec      *prometheus.HistogramVec
+	retries      *prometheus.CounterVec
+	throttled    *prometheus.CounterVec
+	circuit      *prometheus.GaugeVec
+	retry        *retryPolicy
+	limiter      *limiter
+	breaker      *breaker
+	lastGood     *ttlcache.Cache[string, *types.ParsedHttpResponse]
+	methods      map[string]bool
+	varyHeaders  []string
+	varies       sync.Map
+	refreshes    singleflight.Group
+	refreshing   sync.WaitGroup
+	staleCounter *prometheus.CounterVec
+	itemsGauge   prometheus.GaugeFunc
+	bytesGauge   prometheus.GaugeFunc
+	hc           http.Client
 }
 
 func (h *hcServiceImpl) RoundTrip(req *http.Request) (*http.Response, error) {
 	var (
 		resp       *http.Response
 		cachedResp *types.ParsedHttpResponse
-		body       bytes.Buffer
 		err        error
+		key        string
+		primaryKey string
 	)
 
-	if *h.cfg.Cache.Enabled {
-		if cachedResp = h.get(req.URL.String()); cachedResp != nil {
-			h.l.Debug("using cached response", "url", req.URL.String())
-			return cachedResp.AsHttpResponse(), nil
+	if primaryKey, err = cacheKey(req, h.varyHeaders); err != nil {
+		h.l.Debug("request can't be cached", "url", req.URL.String(), "err", err.Error())
+	} else {
+		key = h.variantKey(req, primaryKey)
+	}
+	cacheable := *h.cfg.Cache.Enabled && len(key) > 0 && h.methods[req.Method]
+
+	if cacheable {
+		if cachedResp = h.get(key); cachedResp != nil {
+			now := time.Now()
+			if cachedResp.IsFresh(now) {
+				h.onCacheHit(key)
+				h.l.Debug("using cached response", "url", req.URL.String())
+				return cachedResp.AsHttpResponse(), nil
+			}
+			if h.canServeStale(cachedResp, h.cfg.Cache.StaleWhileRevalidate, now) {
+				h.onCacheHit(key)
+				h.onStale(key, "revalidate")
+				h.l.Debug("using stale response while revalidating", "url", req.URL.String())
+				h.refreshInBackground(req, key, primaryKey, cachedResp)
+				return staleCopy(cachedResp).AsHttpResponse(), nil
+			}
+		}
+	}
+
+	resp, err = h.fetch(req, key, primaryKey, cachedResp, cacheable)
+	if (err != nil || resp.StatusCode >= 500) && h.canServeStale(cachedResp, h.cfg.Cache.StaleIfError, time.Now()) {
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		h.onStale(key, "error")
+		h.l.Debug("using stale response because of error", "url", req.URL.String())
+		return staleCopy(cachedResp).AsHttpResponse(), nil
+	}
+	return resp, err
+}
+
+// fetch sends request to server and stores response in cache, if it is cacheable.
+// When cachedResp is given, it's revalidated using conditional request.
+func (h *hcServiceImpl) fetch(req *http.Request, key, primaryKey string,
+	cachedResp *types.ParsedHttpResponse, cacheable bool) (*http.Response, error) {
+	var (
+		resp         *http.Response
+		body         bytes.Buffer
+		err          error
+		revalidating bool
+	)
+
+	if cacheable {
+		if cachedResp != nil && h.httpCache() {
+			req, revalidating = withValidators(req, cachedResp)
+		}
+		if !revalidating {
+			h.onCacheMiss(key)
 		}
 	}
 
@@ -79,12 +158,33 @@ func (h *hcServiceImpl) RoundTrip(req *http.Request) (*http.Response, error) {
 	defer timer.Stop()
 
 	req = req.WithContext(c)
-	resp, err = h.hc.Do(req)
+	resp, err = h.do(req, key, time.Now().Add(*h.cfg.Timeout))
 	if err != nil {
+		if lastGood := h.lastGoodFor(key, err); lastGood != nil {
+			h.onStale(key, staleReason(err))
+			h.l.Debug("using last successful response", "url", req.URL.String(), "reason", staleReason(err))
+			return staleCopy(lastGood).AsHttpResponse(), nil
+		}
 		return nil, err
 	}
+	// only HTTP client service can mark response as stale
+	resp.Header.Del(types.StaleHeader)
+
+	if revalidating {
+		if resp.StatusCode == http.StatusNotModified {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			h.onCacheHit(key)
+			h.onRevalidate(key)
+			h.l.Debug("cached response revalidated", "url", req.URL.String())
+			cachedResp = revalidated(cachedResp, resp)
+			h.store(key, primaryKey, cachedResp)
+			return cachedResp.AsHttpResponse(), nil
+		}
+		h.onCacheMiss(key)
+	}
 
-	if *h.cfg.Cache.Enabled {
+	if cacheable || (h.lastGood != nil && len(key) > 0) {
 		defer func(Body io.ReadCloser) {
 			if err = Body.Close(); err != nil {
 				h.l.Warn("unable to close response body", "err", err.Error())
@@ -94,19 +194,244 @@ func (h *hcServiceImpl) RoundTrip(req *http.Request) (*http.Response, error) {
 		if err != nil {
 			return nil, err
 		}
-		cachedResp = &types.ParsedHttpResponse{
-			StatusCode: resp.StatusCode,
-			Header:     resp.Header,
-			Body:       body.Bytes(),
+		parsed := types.NewParsedHttpResponse(resp, body.Bytes())
+
+		// don't replace response that can still be served on error
+		if cacheable && (resp.StatusCode < 500 || h.cfg.Cache.StaleIfError == nil) {
+			if vary, ok := responseVary(resp.Header); !ok {
+				h.cache.Delete(key)
+			} else {
+				if len(vary) > 0 {
+					h.varies.Store(primaryKey, vary)
+					key = h.variantKey(req, primaryKey)
+				}
+				h.store(key, primaryKey, parsed)
+			}
+		}
+		if h.lastGood != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			h.lastGood.Set(key, parsed, ttlcache.DefaultTTL)
+		}
+		return parsed.AsHttpResponse(), nil
+	}
+
+	return resp, nil
+}
+
+// refreshInBackground refreshes stale cached response, unless refresh of same key is already in progress.
+func (h *hcServiceImpl) refreshInBackground(req *http.Request, key, primaryKey string, cachedResp *types.ParsedHttpResponse) {
+	req = req.Clone(context.Background())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return
+		}
+		req.Body = body
+	}
+	h.refreshing.Go(func() {
+		_, _, _ = h.refreshes.Do(key, func() (interface{}, error) {
+			resp, err := h.fetch(req, key, primaryKey, cachedResp, true)
+			if err != nil {
+				h.l.Warn("background refresh failed", "url", req.URL.String(), "err", err.Error())
+				return nil, err
+			}
+			_ = resp.Body.Close()
+			return nil, nil
+		})
+	})
+}
+
+// variantKey extends primary cache key with values of request headers that previous response varied on.
+func (h *hcServiceImpl) variantKey(req *http.Request, primaryKey string) string {
+	vary, ok := h.varies.Load(primaryKey)
+	if !ok {
+		return primaryKey
+	}
+	key, err := cacheKey(req, append(slices.Clone(h.varyHeaders), vary.([]string)...))
+	if err != nil {
+		return primaryKey
+	}
+	return key
+}
+
+// httpCache checks whether cache follows HTTP caching semantics.
+func (h *hcServiceImpl) httpCache() bool {
+	return h.cfg.Cache.Mode != nil && *h.cfg.Cache.Mode == types.CacheModeHttp
+}
+
+// canServeStale checks whether cached response can be served stale within given window.
+// When cache follows HTTP caching semantics, responses that must be revalidated are never served stale.
+func (h *hcServiceImpl) canServeStale(r *types.ParsedHttpResponse, window *time.Duration, now time.Time) bool {
+	if h.httpCache() && r != nil && forbidsStale(r) {
+		return false
+	}
+	return withinStaleWindow(r, window, now)
+}
+
+// store puts response into cache and sets its freshness.
+func (h *hcServiceImpl) store(key, primaryKey string, r *types.ParsedHttpResponse) {
+	ttl := *h.cfg.Cache.TTL
+	if h.httpCache() {
+		lifetime, ok := httpFreshness(r, ttl)
+		if !ok {
+			h.cache.Delete(key)
+			return
+		}
+		// keep responses that can be revalidated at least for configured TTL
+		if !hasValidators(r) {
+			ttl = lifetime
+		}
+		r.FreshUntil = r.StoredAt.Add(lifetime)
+		ttl = max(ttl, lifetime)
+	} else {
+		r.FreshUntil = r.StoredAt.Add(ttl)
+	}
+	// keep stale responses as long as they can be served
+	if stale := max(lo.FromPtr(h.cfg.Cache.StaleWhileRevalidate), lo.FromPtr(h.cfg.Cache.StaleIfError)); stale > 0 {
+		ttl = max(ttl, r.FreshUntil.Sub(r.StoredAt)+stale)
+	}
+	if ttl <= 0 {
+		h.cache.Delete(key)
+		return
+	}
+	h.cache.Set(key, primaryKey, r, ttl)
+}
+
+// do sends request, retrying it according to retry policy as long as next attempt can start before deadline.
+func (h *hcServiceImpl) do(req *http.Request, key string, deadline time.Time) (*http.Response, error) {
+	if h.retry == nil || !h.retry.methods[req.Method] {
+		return h.send(req, key)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := h.send(req, key)
+		if attempt >= h.retry.maxAttempts || !h.retry.shouldRetry(resp, err) {
+			return resp, err
+		}
+		// body can't be sent again
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		wait := h.retry.backoff(attempt, resp)
+		if time.Now().Add(wait).After(deadline) {
+			return resp, err
 		}
+		reason := "error"
+		if resp != nil {
+			reason = strconv.Itoa(resp.StatusCode)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		h.l.Debug("retrying request", "url", req.URL.String(), "attempt", attempt+1, "wait", wait, "reason", reason)
+		h.onRetry(req.URL.Host, reason)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// staleOn checks whether last successful response should be served on given error.
+// staleMaxAge gets maximum age of last successful response that can be served instead of failing with err.
+func (h *hcServiceImpl) staleMaxAge(err error) time.Duration {
+	switch {
+	case errors.Is(err, errThrottled) && h.cfg.RateLimit != nil:
+		return lo.FromPtrOr(h.cfg.RateLimit.StaleMaxAge, types.DefaultStaleMaxAge)
+	case errors.Is(err, errCircuitOpen) && h.cfg.CircuitBreaker != nil:
+		return lo.FromPtrOr(h.cfg.CircuitBreaker.StaleMaxAge, types.DefaultStaleMaxAge)
+	default:
+		return types.DefaultStaleMaxAge
+	}
+}
+
+// staleReason gets reason of serving last successful response instead of failing with err, as reported by metrics.
+func staleReason(err error) string {
+	if errors.Is(err, errThrottled) {
+		return "throttled"
+	}
+	return "circuit_open"
+}
+
+// lastGoodFor gets last successful response of request that can be served instead of failing with err,
+// or nil if there is none that isn't too old.
+func (h *hcServiceImpl) lastGoodFor(key string, err error) *types.ParsedHttpResponse {
+	if len(key) == 0 || !h.staleOn(err) {
+		return nil
+	}
+	i := h.lastGood.Get(key)
+	if i == nil || time.Since(i.Value().StoredAt) > h.staleMaxAge(err) {
+		return nil
+	}
+	return i.Value()
+}
 
-		h.cache.Set(req.URL.String(), cachedResp, ttlcache.DefaultTTL)
-		return cachedResp.AsHttpResponse(), nil
+func (h *hcServiceImpl) staleOn(err error) bool {
+	switch {
+	case errors.Is(err, errThrottled):
+		return h.limiter != nil && h.limiter.onLimit == types.OnLimitStale
+	case errors.Is(err, errCircuitOpen):
+		return h.breaker != nil && lo.FromPtr(h.cfg.CircuitBreaker.ServeStale)
+	default:
+		return false
 	}
+}
 
+// send sends single request once permit from limiter is obtained.
+func (h *hcServiceImpl) send(req *http.Request, key string) (*http.Response, error) {
+	release, err := h.acquire(req, key)
+	if err != nil {
+		return nil, err
+	}
+	if h.breaker != nil && !h.breaker.allow(req.URL.Host) {
+		release()
+		return nil, errCircuitOpen
+	}
+	resp, err := h.hc.Do(req)
+	if h.breaker != nil {
+		h.breaker.record(req.URL.Host, err == nil && resp.StatusCode < 500)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
 	return resp, nil
 }
 
+// acquire obtains permit from limiter. Returned function releases it.
+func (h *hcServiceImpl) acquire(req *http.Request, key string) (func(), error) {
+	if h.limiter == nil {
+		return func() {}, nil
+	}
+	hl := h.limiter.forHost(req.URL.Host)
+	release, ok := hl.tryAcquire()
+	if !ok {
+		action := h.limiter.onLimit
+		if action == types.OnLimitStale && h.lastGoodFor(key, errThrottled) == nil {
+			action = types.OnLimitWait
+		}
+		h.onThrottle(req.URL.Host, action)
+		if action != types.OnLimitWait {
+			return nil, errThrottled
+		}
+		ctx := req.Context()
+		if h.limiter.maxWait > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.limiter.maxWait)
+			defer cancel()
+		}
+		return hl.acquire(ctx)
+	}
+	return release, nil
+}
+
 func (h *hcServiceImpl) RoundTripper() http.RoundTripper {
 	return h
 }
@@ -115,10 +440,17 @@ func (h *hcServiceImpl) Describe(ch chan<- *prometheus.Desc) {
 	if *h.cfg.Cache.Enabled && *h.cfg.Cache.Instrumentation.Enabled {
 		h.missCounter.Describe(ch)
 		h.hitCounter.Describe(ch)
+		h.revalCounter.Describe(ch)
+		h.staleCounter.Describe(ch)
+		h.itemsGauge.Describe(ch)
+		h.bytesGauge.Describe(ch)
 	}
 	if *h.cfg.Instrumentation.Enabled {
 		h.counter.Describe(ch)
 		h.hitCounter.Describe(ch)
+		h.retries.Describe(ch)
+		h.throttled.Describe(ch)
+		h.circuit.Describe(ch)
 	}
 }
 
@@ -126,32 +458,76 @@ func (h *hcServiceImpl) Collect(ch chan<- prometheus.Metric) {
 	if *h.cfg.Cache.Enabled && *h.cfg.Cache.Instrumentation.Enabled {
 		h.missCounter.Collect(ch)
 		h.hitCounter.Collect(ch)
+		h.revalCounter.Collect(ch)
+		h.staleCounter.Collect(ch)
+		h.itemsGauge.Collect(ch)
+		h.bytesGauge.Collect(ch)
 	}
 	if *h.cfg.Instrumentation.Enabled {
 		h.counter.Collect(ch)
 		h.hitCounter.Collect(ch)
+		h.retries.Collect(ch)
+		h.throttled.Collect(ch)
+		h.circuit.Collect(ch)
 	}
 }
 
 func (h *hcServiceImpl) Start() (err error) {
 	h.l.Info("starting cache service")
 	h.cfg = *fluent.NewConfigHelper[types.HttpClientServiceConfig]().Add(*defCfg).Add(h.cfg).Result()
-	h.cache = ttlcache.New[string, *types.ParsedHttpResponse](
-		ttlcache.WithDisableTouchOnHit[string, *types.ParsedHttpResponse](),
-		ttlcache.WithTTL[string, *types.ParsedHttpResponse](*h.cfg.Cache.TTL),
-		ttlcache.WithCapacity[string, *types.ParsedHttpResponse](uint64(*h.cfg.Cache.Capacity)),
-	)
-	go h.cache.Start()
+	if h.cache, err = newCacheStore(h.cfg.Cache, h.l); err != nil {
+		return err
+	}
+	if err = h.cache.Start(); err != nil {
+		return err
+	}
+	for primaryKey, vary := range h.cache.Varies() {
+		h.varies.Store(primaryKey, vary)
+	}
+
+	h.methods = lo.SliceToMap(lo.Ternary(len(h.cfg.Cache.Methods) > 0, h.cfg.Cache.Methods, types.DefaultCacheMethods),
+		func(m string) (string, bool) {
+			return strings.ToUpper(m), true
+		})
+	h.varyHeaders = lo.Ternary(h.cfg.Cache.VaryHeaders != nil, h.cfg.Cache.VaryHeaders, types.DefaultCacheVaryHeaders)
 
 	if *h.cfg.Cache.Instrumentation.Enabled {
 		h.hitCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: *h.cfg.Cache.Instrumentation.Prefix + "_hit",
-			Help: "HTTP response cache hit count",
+			Name:        *h.cfg.Cache.Instrumentation.Prefix + "_hit",
+			Help:        "HTTP response cache hit count",
+			ConstLabels: h.constLabels(),
 		}, []string{"host"})
 		h.missCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: *h.cfg.Cache.Instrumentation.Prefix + "_miss",
-			Help: "HTTP response cache miss count",
+			Name:        *h.cfg.Cache.Instrumentation.Prefix + "_miss",
+			Help:        "HTTP response cache miss count",
+			ConstLabels: h.constLabels(),
 		}, []string{"host"})
+		h.revalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        *h.cfg.Cache.Instrumentation.Prefix + "_revalidated",
+			Help:        "HTTP response cache count of stale responses revalidated by server",
+			ConstLabels: h.constLabels(),
+		}, []string{"host"})
+		h.staleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        *h.cfg.Cache.Instrumentation.Prefix + "_stale",
+			Help:        "HTTP response cache count of stale responses served, by reason",
+			ConstLabels: h.constLabels(),
+		}, []string{"host", "reason"})
+		h.itemsGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        *h.cfg.Cache.Instrumentation.Prefix + "_items",
+			Help:        "HTTP response cache current number of entries",
+			ConstLabels: h.constLabels(),
+		}, func() float64 {
+			return float64(len(h.CacheEntries()))
+		})
+		h.bytesGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        *h.cfg.Cache.Instrumentation.Prefix + "_bytes",
+			Help:        "HTTP response cache current total size of response bodies in bytes",
+			ConstLabels: h.constLabels(),
+		}, func() float64 {
+			return float64(lo.SumBy(h.CacheEntries(), func(e types.CacheEntry) int {
+				return e.Size
+			}))
+		})
 	}
 
 	hc := http.Client{}
@@ -159,28 +535,89 @@ func (h *hcServiceImpl) Start() (err error) {
 		hc.Timeout = *h.cfg.Timeout
 	}
 
+	var rt http.RoundTripper
+	if rt, err = newTransport(&h.cfg, h.l); err != nil {
+		return err
+	}
+	hc.Transport = rt
+
+	if h.cfg.Retry != nil {
+		h.retry = newRetryPolicy(h.cfg.Retry)
+	}
+
+	if h.cfg.RateLimit != nil {
+		h.limiter = newLimiter(h.cfg.RateLimit)
+	}
+
+	if h.cfg.CircuitBreaker != nil {
+		h.breaker = newBreaker(h.cfg.CircuitBreaker, h.onCircuitChange)
+	}
+
+	if h.staleOn(errThrottled) || h.staleOn(errCircuitOpen) {
+		// keep responses as long as any of stale actions can serve them
+		ttl := lo.Max(lo.FilterMap([]error{errThrottled, errCircuitOpen}, func(err error, _ int) (time.Duration, bool) {
+			return h.staleMaxAge(err), h.staleOn(err)
+		}))
+		h.lastGood = ttlcache.New[string, *types.ParsedHttpResponse](
+			ttlcache.WithTTL[string, *types.ParsedHttpResponse](ttl),
+			ttlcache.WithCapacity[string, *types.ParsedHttpResponse](uint64(*h.cfg.Cache.Capacity)),
+		)
+	}
+
 	if *h.cfg.Instrumentation.Enabled {
 		h.counter = prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Namespace: *h.cfg.Instrumentation.Prefix,
-				Name:      "http_client_requests_total",
-				Help:      "A counter for requests from the wrapped client.",
+				Namespace:   *h.cfg.Instrumentation.Prefix,
+				Name:        "http_client_requests_total",
+				Help:        "A counter for requests from the wrapped client.",
+				ConstLabels: h.constLabels(),
 			},
 			[]string{"code", "method"},
 		)
 
+		h.retries = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   *h.cfg.Instrumentation.Prefix,
+				Name:        "http_client_retries_total",
+				Help:        "A counter for retried requests from the wrapped client.",
+				ConstLabels: h.constLabels(),
+			},
+			[]string{"host", "reason"},
+		)
+
+		h.circuit = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   *h.cfg.Instrumentation.Prefix,
+				Name:        "http_client_circuit_state",
+				Help:        "State of circuit breaker per host (0 = closed, 1 = open, 2 = half-open).",
+				ConstLabels: h.constLabels(),
+			},
+			[]string{"host"},
+		)
+
+		h.throttled = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   *h.cfg.Instrumentation.Prefix,
+				Name:        "http_client_throttled_total",
+				Help:        "A counter for requests from the wrapped client that hit rate or concurrency limit.",
+				ConstLabels: h.constLabels(),
+			},
+			[]string{"host", "action"},
+		)
+
 		h.histVec = prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Namespace: *h.cfg.Instrumentation.Prefix,
-				Name:      "http_client_request_duration_seconds",
-				Help:      "A histogram of request latencies.",
-				Buckets:   prometheus.DefBuckets,
+				Namespace:   *h.cfg.Instrumentation.Prefix,
+				Name:        "http_client_request_duration_seconds",
+				Help:        "A histogram of request latencies.",
+				Buckets:     prometheus.DefBuckets,
+				ConstLabels: h.constLabels(),
 			},
 			[]string{},
 		)
 
 		hc.Transport = promhttp.InstrumentRoundTripperCounter(h.counter,
-			promhttp.InstrumentRoundTripperDuration(h.histVec, http.DefaultTransport),
+			promhttp.InstrumentRoundTripperDuration(h.histVec, rt),
 		)
 	}
 
@@ -189,14 +626,79 @@ func (h *hcServiceImpl) Start() (err error) {
 	return nil
 }
 
-func (h *hcServiceImpl) Close() error {
+func (h *hcServiceImpl) CacheEntries() []types.CacheEntry {
+	if h.cache == nil {
+		return nil
+	}
+	return h.cache.Entries()
+}
+
+// PurgeCache removes matching entries from cache, along with last successful responses and recorded Vary headers,
+// so that purged responses aren't served in any way.
+func (h *hcServiceImpl) PurgeCache(match func(key string) bool) int {
+	purged := make(map[string]bool)
+	for _, e := range h.CacheEntries() {
+		if match(e.Key) {
+			h.cache.Delete(e.Key)
+			purged[e.Key] = true
+		}
+	}
+	if h.lastGood != nil {
+		for _, key := range h.lastGood.Keys() {
+			if match(key) {
+				h.lastGood.Delete(key)
+				purged[key] = true
+			}
+		}
+	}
+	h.varies.Range(func(key, _ any) bool {
+		if match(key.(string)) {
+			h.varies.Delete(key)
+		}
+		return true
+	})
+	if len(purged) > 0 {
+		h.l.Info("purged cache entries", "count", len(purged))
+	}
+	return len(purged)
+}
+
+func (h *hcServiceImpl) Close() (err error) {
+	// background refreshes are bounded by client timeout
+	h.refreshing.Wait()
 	if h.cache != nil {
 		h.l.Info("stopping cache service")
-		h.cache.Stop()
+		err = h.cache.Close()
 		h.cache = nil
 	}
 	h.started = false
-	return nil
+	return err
+}
+
+// constLabels are attached to all metrics, so that metrics of different client profiles don't collide.
+func (h *hcServiceImpl) constLabels() prometheus.Labels {
+	return prometheus.Labels{"client": h.name}
+}
+
+func (h *hcServiceImpl) onRetry(host, reason string) {
+	if h.retries != nil {
+		h.retries.WithLabelValues(host, reason).Inc()
+	}
+}
+
+func (h *hcServiceImpl) onCircuitChange(host string, from, to circuitState) {
+	if h.circuit != nil {
+		h.circuit.WithLabelValues(host).Set(float64(to))
+	}
+	if from != to {
+		h.l.Info("circuit breaker state changed", "host", host, "from", from.String(), "to", to.String())
+	}
+}
+
+func (h *hcServiceImpl) onThrottle(host string, action types.OnLimitAction) {
+	if h.throttled != nil {
+		h.throttled.WithLabelValues(host, string(action)).Inc()
+	}
 }
 
 func (h *hcServiceImpl) onCacheMiss(key string) {
@@ -205,6 +707,18 @@ func (h *hcServiceImpl) onCacheMiss(key string) {
 	}
 }
 
+func (h *hcServiceImpl) onStale(key, reason string) {
+	if h.staleCounter != nil {
+		h.staleCounter.WithLabelValues(key2host(key), reason).Inc()
+	}
+}
+
+func (h *hcServiceImpl) onRevalidate(key string) {
+	if h.revalCounter != nil {
+		h.revalCounter.WithLabelValues(key2host(key)).Inc()
+	}
+}
+
 func (h *hcServiceImpl) onCacheHit(key string) {
 	if h.hitCounter != nil {
 		h.hitCounter.WithLabelValues(key2host(key)).Inc()
@@ -212,19 +726,15 @@ func (h *hcServiceImpl) onCacheHit(key string) {
 }
 
 func (h *hcServiceImpl) get(key string) *types.ParsedHttpResponse {
-	i := h.cache.Get(key)
-	if i == nil {
-		h.onCacheMiss(key)
-		return nil
-	}
-	h.onCacheHit(key)
-	return i.Value()
+	return h.cache.Get(key)
 }
 
-func NewHttpClient(cfg types.HttpClientServiceConfig, l *slog.Logger, reg prometheus.Registerer) types.HttpClientService {
+// NewHttpClient creates HttpClientService for named client profile.
+func NewHttpClient(name string, cfg types.HttpClientServiceConfig, l *slog.Logger, reg prometheus.Registerer) types.HttpClientService {
 	return &hcServiceImpl{
-		cfg: cfg,
-		l:   l,
-		reg: reg,
+		name: name,
+		cfg:  cfg,
+		l:    l.With("client", name),
+		reg:  reg,
 	}
 }
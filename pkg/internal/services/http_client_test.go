@@ -0,0 +1,727 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+// newTestClient creates and starts HTTP client service. Configuration can be adjusted by mut function.
+// Cache is disabled unless enabled by mut.
+func newTestClient(t *testing.T, mut func(c *types.HttpClientServiceConfig)) *hcServiceImpl {
+	t.Helper()
+	h, err := startTestClient(t, mut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// startTestClient is like newTestClient, but returns error of start.
+func startTestClient(t *testing.T, mut func(c *types.HttpClientServiceConfig)) (*hcServiceImpl, error) {
+	t.Helper()
+	cfg := types.HttpClientServiceConfig{
+		Timeout:         lo.ToPtr(time.Second * 5),
+		Instrumentation: &types.InstrumentationConfigFragment{Enabled: lo.ToPtr(true), Prefix: lo.ToPtr("test")},
+		Cache: &types.CacheConfig{
+			Enabled:         lo.ToPtr(false),
+			TTL:             lo.ToPtr(time.Minute),
+			Capacity:        lo.ToPtr(10),
+			Instrumentation: &types.InstrumentationConfigFragment{Enabled: lo.ToPtr(true), Prefix: lo.ToPtr("test_cache")},
+		},
+	}
+	if mut != nil {
+		mut(&cfg)
+	}
+	h := NewHttpClient("default", cfg, slog.New(slog.DiscardHandler), prometheus.NewRegistry()).(*hcServiceImpl)
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+	return h, h.Start()
+}
+
+// send sends request with given method and body through client, returning response along with its body.
+func send(t *testing.T, h *hcServiceImpl, method, url string, body io.Reader, hdr ...string) (*http.Response, string, error) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	resp, err := h.RoundTrip(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(resp.Body)
+	return resp, string(data), err
+}
+
+// get sends GET request and fails test on error.
+func get(t *testing.T, h *hcServiceImpl, url string, hdr ...string) (*http.Response, string) {
+	t.Helper()
+	resp, body, err := send(t, h, http.MethodGet, url, nil, hdr...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+// stale checks whether response was served stale.
+func stale(resp *http.Response) bool {
+	return types.NewParsedHttpResponse(resp, nil).Stale
+}
+
+// counting creates test server that counts requests and serves them by given handler.
+func counting(t *testing.T, fn func(w http.ResponseWriter, r *http.Request, call int)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fn(w, r, int(calls.Add(1)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestRetry(t *testing.T) {
+	srv, calls := counting(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		body, _ := io.ReadAll(r.Body)
+		if call < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "ok %s", body)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Retry = &types.RetryConfig{}
+	})
+	resp, body := get(t, h, srv.URL)
+	if resp.StatusCode != http.StatusOK || body != "ok " || calls.Load() != 3 {
+		t.Errorf("expected success after 3 attempts, got %d %q after %d", resp.StatusCode, body, calls.Load())
+	}
+	if v := testutil.ToFloat64(h.retries.WithLabelValues(srv.Listener.Addr().String(), "503")); v != 2 {
+		t.Errorf("expected 2 retries counted, got %v", v)
+	}
+}
+
+func TestRetryMethods(t *testing.T) {
+	srv, calls := counting(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		body, _ := io.ReadAll(r.Body)
+		if call%2 == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "ok %s", body)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Retry = &types.RetryConfig{BaseBackoff: lo.ToPtr(time.Millisecond)}
+	})
+	resp, _, err := send(t, h, http.MethodPost, srv.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadGateway || calls.Load() != 1 {
+		t.Errorf("POST must not be retried by default, got %d after %d attempts", resp.StatusCode, calls.Load())
+	}
+
+	calls.Store(0)
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Retry = &types.RetryConfig{BaseBackoff: lo.ToPtr(time.Millisecond), Methods: []string{"get", "post"}}
+	})
+	resp, body, err := send(t, h, http.MethodPost, srv.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || body != "ok payload" || calls.Load() != 2 {
+		t.Errorf("opted-in POST must be retried with same body, got %d %q after %d attempts", resp.StatusCode, body, calls.Load())
+	}
+}
+
+func TestRetryWithinTimeout(t *testing.T) {
+	srv, calls := counting(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Timeout = lo.ToPtr(time.Second)
+		c.Retry = &types.RetryConfig{MaxAttempts: lo.ToPtr(100), RetryAfter: lo.ToPtr(false),
+			BaseBackoff: lo.ToPtr(time.Millisecond * 300), Jitter: lo.ToPtr(0.0)}
+	})
+	start := time.Now()
+	resp, _ := get(t, h, srv.URL)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected last response to be returned, got %d", resp.StatusCode)
+	}
+	// attempts at 0, 300ms and 900ms, next one would be at 2.1s
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond || calls.Load() != 3 {
+		t.Errorf("attempts must fit into timeout, got %d attempts in %v", calls.Load(), elapsed)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		mode    types.OnLimitAction
+		calls   int32
+		bodies  []string
+		failed  int
+		minTime time.Duration
+	}{
+		{mode: types.OnLimitWait, calls: 4, bodies: []string{"ok 1", "ok 2", "ok 3", "ok 4"}, minTime: 500 * time.Millisecond},
+		{mode: types.OnLimitStale, calls: 1, bodies: []string{"ok 1", "ok 1 (stale)", "ok 1 (stale)", "ok 1 (stale)"}},
+		{mode: types.OnLimitFail, calls: 1, bodies: []string{"ok 1"}, failed: 3},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			srv, calls := counting(t, func(w http.ResponseWriter, _ *http.Request, call int) {
+				_, _ = fmt.Fprintf(w, "ok %d", call)
+			})
+			h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+				c.RateLimit = &types.RateLimitConfig{Rate: lo.ToPtr(5.0), OnLimit: lo.ToPtr(tc.mode)}
+			})
+			var (
+				bodies []string
+				failed int
+			)
+			start := time.Now()
+			for range 4 {
+				resp, body, err := send(t, h, http.MethodGet, srv.URL, nil)
+				if err != nil {
+					failed++
+					continue
+				}
+				if stale(resp) {
+					body += " (stale)"
+				}
+				bodies = append(bodies, body)
+			}
+			if elapsed := time.Since(start); elapsed < tc.minTime {
+				t.Errorf("expected requests to take at least %v, took %v", tc.minTime, elapsed)
+			}
+			if calls.Load() != tc.calls || failed != tc.failed || strings.Join(bodies, ",") != strings.Join(tc.bodies, ",") {
+				t.Errorf("unexpected outcome: %d calls, %d failed, bodies %v", calls.Load(), failed, bodies)
+			}
+			if v := testutil.ToFloat64(h.throttled.WithLabelValues(srv.Listener.Addr().String(), string(tc.mode))); v != 3 {
+				t.Errorf("expected 3 throttled requests, got %v", v)
+			}
+			served := testutil.ToFloat64(h.staleCounter.WithLabelValues(srv.Listener.Addr().String(), "throttled"))
+			if exp := float64(len(tc.bodies) - int(tc.calls)); served != exp {
+				t.Errorf("expected %v stale responses counted, got %v", exp, served)
+			}
+		})
+	}
+}
+
+func TestRateLimitStaleMaxAge(t *testing.T) {
+	srv, calls := counting(t, func(w http.ResponseWriter, _ *http.Request, call int) {
+		_, _ = fmt.Fprintf(w, "ok %d", call)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.RateLimit = &types.RateLimitConfig{Rate: lo.ToPtr(0.1), OnLimit: lo.ToPtr(types.OnLimitStale),
+			MaxWait: lo.ToPtr(50 * time.Millisecond), StaleMaxAge: lo.ToPtr(100 * time.Millisecond)}
+	})
+	get(t, h, srv.URL)
+	if resp, body := get(t, h, srv.URL); body != "ok 1" || !stale(resp) {
+		t.Errorf("expected stale response, got %q (stale: %v)", body, stale(resp))
+	}
+	time.Sleep(150 * time.Millisecond)
+	// response is too old to be served, so request waits for permit and fails after maxWait
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error once last successful response is too old")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	unblock := make(chan struct{})
+	srv, calls := counting(t, func(w http.ResponseWriter, _ *http.Request, call int) {
+		if call == 1 {
+			<-unblock
+		}
+		_, _ = fmt.Fprintf(w, "ok %d", call)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.RateLimit = &types.RateLimitConfig{MaxInFlight: lo.ToPtr(1), OnLimit: lo.ToPtr(types.OnLimitFail)}
+	})
+	done := make(chan string)
+	go func() {
+		_, body, _ := send(t, h, http.MethodGet, srv.URL, nil)
+		done <- body
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected request over concurrency limit to fail")
+	}
+	close(unblock)
+	if body := <-done; body != "ok 1" {
+		t.Errorf("unexpected body of first request %q", body)
+	}
+	// permit is released once body of first response is consumed
+	if _, body := get(t, h, srv.URL); body != "ok 2" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var fail atomic.Bool
+	srv, calls := counting(t, func(w http.ResponseWriter, _ *http.Request, call int) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "ok %d", call)
+	})
+	for _, serveStale := range []bool{false, true} {
+		t.Run(fmt.Sprintf("serveStale=%v", serveStale), func(t *testing.T) {
+			fail.Store(false)
+			calls.Store(0)
+			h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+				c.CircuitBreaker = &types.CircuitBreakerConfig{FailureThreshold: lo.ToPtr(2),
+					CoolDown: lo.ToPtr(200 * time.Millisecond), ServeStale: lo.ToPtr(serveStale)}
+			})
+			state := h.circuit.WithLabelValues(srv.Listener.Addr().String())
+			step := func(name string, expStatus int, expBody string, expStale bool, expCalls int32, expState circuitState) {
+				t.Helper()
+				resp, body, err := send(t, h, http.MethodGet, srv.URL, nil)
+				status, isStale := 0, false
+				if err == nil {
+					status, isStale = resp.StatusCode, stale(resp)
+				}
+				if status != expStatus || body != expBody || isStale != expStale || calls.Load() != expCalls {
+					t.Errorf("%s: expected %d %q (stale: %v) after %d calls, got %d %q (stale: %v) after %d calls (err: %v)",
+						name, expStatus, expBody, expStale, expCalls, status, body, isStale, calls.Load(), err)
+				}
+				if s := circuitState(testutil.ToFloat64(state)); s != expState {
+					t.Errorf("%s: expected circuit to be %s, got %s", name, expState, s)
+				}
+			}
+			// response served while circuit is open
+			openStatus, openBody := 0, ""
+			if serveStale {
+				openStatus, openBody = http.StatusOK, "ok 1"
+			}
+			step("success", http.StatusOK, "ok 1", false, 1, circuitClosed)
+			fail.Store(true)
+			step("first failure", http.StatusBadGateway, "", false, 2, circuitClosed)
+			step("threshold reached", http.StatusBadGateway, "", false, 3, circuitOpen)
+			step("open", openStatus, openBody, serveStale, 3, circuitOpen)
+			time.Sleep(250 * time.Millisecond)
+			step("failed probe", http.StatusBadGateway, "", false, 4, circuitOpen)
+			step("open again", openStatus, openBody, serveStale, 4, circuitOpen)
+			fail.Store(false)
+			time.Sleep(250 * time.Millisecond)
+			step("successful probe", http.StatusOK, "ok 5", false, 5, circuitClosed)
+			step("closed", http.StatusOK, "ok 6", false, 6, circuitClosed)
+			served := testutil.ToFloat64(h.staleCounter.WithLabelValues(srv.Listener.Addr().String(), "circuit_open"))
+			if exp := lo.Ternary(serveStale, 2.0, 0.0); served != exp {
+				t.Errorf("expected %v stale responses, got %v", exp, served)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerStaleMaxAge(t *testing.T) {
+	var fail atomic.Bool
+	srv, calls := counting(t, func(w http.ResponseWriter, _ *http.Request, call int) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "ok %d", call)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.CircuitBreaker = &types.CircuitBreakerConfig{FailureThreshold: lo.ToPtr(1), CoolDown: lo.ToPtr(time.Minute),
+			ServeStale: lo.ToPtr(true), StaleMaxAge: lo.ToPtr(100 * time.Millisecond)}
+	})
+	get(t, h, srv.URL)
+	fail.Store(true)
+	if resp, _, err := send(t, h, http.MethodGet, srv.URL, nil); err != nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected failure to open circuit, got %v", err)
+	}
+	if resp, body := get(t, h, srv.URL); body != "ok 1" || !stale(resp) {
+		t.Errorf("expected stale response, got %q (stale: %v)", body, stale(resp))
+	}
+	time.Sleep(150 * time.Millisecond)
+	// response is too old to be served, so request fails on open circuit
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error once last successful response is too old")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls, got %d", calls.Load())
+	}
+}
+
+func TestHttpCacheMode(t *testing.T) {
+	srv, calls := counting(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "max-age=1")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/expires":
+			w.Header().Set("Expires", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		}
+		_, _ = fmt.Fprintf(w, "body %d", call)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Cache.Enabled = lo.ToPtr(true)
+		c.Cache.Mode = lo.ToPtr(types.CacheModeHttp)
+	})
+	for _, tc := range []struct {
+		path   string
+		calls  int32
+		bodies string
+	}{
+		{path: "/max-age", calls: 1, bodies: "body 1,body 1,body 1"},
+		{path: "/no-store", calls: 3, bodies: "body 1,body 2,body 3"},
+		{path: "/etag", calls: 3, bodies: "body 1,body 1,body 1"},
+		{path: "/expires", calls: 3, bodies: "body 1,body 2,body 3"},
+		// no freshness information, cached for configured TTL
+		{path: "/plain", calls: 1, bodies: "body 1,body 1,body 1"},
+	} {
+		t.Run(tc.path[1:], func(t *testing.T) {
+			calls.Store(0)
+			var bodies []string
+			for range 3 {
+				resp, body := get(t, h, srv.URL+tc.path)
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("unexpected status %d", resp.StatusCode)
+				}
+				bodies = append(bodies, body)
+			}
+			if calls.Load() != tc.calls || strings.Join(bodies, ",") != tc.bodies {
+				t.Errorf("expected %q after %d calls, got %v after %d calls", tc.bodies, tc.calls, bodies, calls.Load())
+			}
+		})
+	}
+	if v := testutil.ToFloat64(h.revalCounter.WithLabelValues(srv.Listener.Addr().String())); v != 2 {
+		t.Errorf("expected 2 revalidations, got %v", v)
+	}
+
+	calls.Store(0)
+	time.Sleep(1100 * time.Millisecond)
+	if _, body := get(t, h, srv.URL+"/max-age"); body != "body 1" || calls.Load() != 1 {
+		t.Errorf("expired response must be fetched again, got %q after %d calls", body, calls.Load())
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/x?a=1", nil)
+	if key, err := cacheKey(req, []string{"authorization"}); err != nil || key != "GET http://localhost/x?a=1" {
+		t.Errorf("unexpected key %q (err: %v)", key, err)
+	}
+	req.Header.Set("Authorization", "secret")
+	key, err := cacheKey(req, []string{"authorization"})
+	if err != nil || !strings.HasPrefix(key, "GET http://localhost/x?a=1 ") || strings.Contains(key, "secret") {
+		t.Errorf("header value must be hashed into key, got %q (err: %v)", key, err)
+	}
+	if other, _ := cacheKey(req, []string{"Accept", "Authorization"}); other != key {
+		t.Errorf("absent header must not change key, got %q and %q", key, other)
+	}
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost/x", io.NopCloser(strings.NewReader("q")))
+	if _, err = cacheKey(req, nil); err == nil {
+		t.Error("expected error for body that can't be replayed")
+	}
+}
+
+func TestCacheKeyVariants(t *testing.T) {
+	srv, calls := counting(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/vary" {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		_, _ = fmt.Fprintf(w, "%d %s %s", call, body, r.Header.Get("Accept-Language"))
+	})
+	// request is described by method, body and header name/value pairs
+	type request struct {
+		method string
+		body   string
+		hdr    []string
+	}
+	for _, tc := range []struct {
+		name    string
+		methods []string
+		path    string
+		reqs    []request
+		bodies  string
+	}{
+		{
+			name: "authorization",
+			reqs: []request{
+				{hdr: []string{"Authorization", "u1"}},
+				{hdr: []string{"Authorization", "u2"}},
+				{hdr: []string{"Authorization", "u1"}},
+			},
+			bodies: "1  ,2  ,1  ",
+		},
+		{
+			name:   "POST not cached by default",
+			reqs:   []request{{method: http.MethodPost, body: "x"}, {method: http.MethodPost, body: "x"}},
+			bodies: "1 x ,2 x ",
+		},
+		{
+			name:    "POST opted in",
+			methods: []string{"get", "post"},
+			reqs: []request{
+				{method: http.MethodPost, body: "x"},
+				{method: http.MethodPost, body: "y"},
+				{method: http.MethodPost, body: "x"},
+			},
+			bodies: "1 x ,2 y ,1 x ",
+		},
+		{
+			name: "vary",
+			path: "/vary",
+			reqs: []request{
+				{hdr: []string{"Accept-Language", "en"}},
+				{hdr: []string{"Accept-Language", "de"}},
+				{hdr: []string{"Accept-Language", "en"}},
+				{hdr: []string{"Accept-Language", "de"}},
+			},
+			bodies: "1  en,2  de,1  en,2  de",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls.Store(0)
+			h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+				c.Cache.Enabled = lo.ToPtr(true)
+				c.Cache.Methods = tc.methods
+			})
+			var bodies []string
+			for _, r := range tc.reqs {
+				var rd io.Reader
+				if len(r.body) > 0 {
+					rd = strings.NewReader(r.body)
+				}
+				_, body, err := send(t, h, lo.CoalesceOrEmpty(r.method, http.MethodGet), srv.URL+tc.path, rd, r.hdr...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				bodies = append(bodies, body)
+			}
+			if s := strings.Join(bodies, ","); s != tc.bodies {
+				t.Errorf("expected %q, got %q", tc.bodies, s)
+			}
+		})
+	}
+}
+
+func TestStaleMarkFromServer(t *testing.T) {
+	srv, _ := counting(t, func(w http.ResponseWriter, _ *http.Request, call int) {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+		w.Header().Set(types.StaleHeader, "true")
+		_, _ = fmt.Fprintf(w, "ok %d", call)
+	})
+	for _, cache := range []bool{false, true} {
+		h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+			c.Cache.Enabled = lo.ToPtr(cache)
+		})
+		resp, _ := get(t, h, srv.URL)
+		if stale(resp) {
+			t.Errorf("cache=%v: response of server must not be reported as stale", cache)
+		}
+		if len(resp.Header.Get("Warning")) == 0 {
+			t.Errorf("cache=%v: Warning header of server must be kept", cache)
+		}
+	}
+}
+
+func TestStaleResponses(t *testing.T) {
+	var fail atomic.Bool
+	srv, calls := counting(t, func(w http.ResponseWriter, _ *http.Request, call int) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		_, _ = fmt.Fprintf(w, "body %d", call)
+	})
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Cache.Enabled = lo.ToPtr(true)
+		c.Cache.TTL = lo.ToPtr(200 * time.Millisecond)
+		c.Cache.StaleWhileRevalidate = lo.ToPtr(300 * time.Millisecond)
+		c.Cache.StaleIfError = lo.ToPtr(time.Second)
+	})
+	step := func(name string, expStatus int, expBody string, expStale bool, expCalls int32) {
+		t.Helper()
+		start := time.Now()
+		resp, body := get(t, h, srv.URL)
+		isStale := stale(resp)
+		if resp.StatusCode != expStatus || body != expBody || isStale != expStale || calls.Load() != expCalls {
+			t.Errorf("%s: expected %d %q (stale: %v) after %d calls, got %d %q (stale: %v) after %d calls",
+				name, expStatus, expBody, expStale, expCalls, resp.StatusCode, body, isStale, calls.Load())
+		}
+		if expStale && time.Since(start) > 50*time.Millisecond {
+			t.Errorf("%s: stale response must be served without waiting for upstream", name)
+		}
+	}
+	step("miss", http.StatusOK, "body 1", false, 1)
+	step("fresh", http.StatusOK, "body 1", false, 1)
+	time.Sleep(250 * time.Millisecond)
+	step("stale while revalidate", http.StatusOK, "body 1", true, 1)
+	time.Sleep(200 * time.Millisecond)
+	step("revalidated in background", http.StatusOK, "body 2", false, 2)
+	fail.Store(true)
+	time.Sleep(600 * time.Millisecond)
+	step("stale on error", http.StatusOK, "body 2", true, 3)
+	time.Sleep(time.Second)
+	step("error after stale window", http.StatusInternalServerError, "", false, 4)
+}
+
+func TestStaleResponsesMustRevalidate(t *testing.T) {
+	var fail atomic.Bool
+	srv, _ := counting(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		_, _ = fmt.Fprintf(w, "body %d", call)
+	})
+	for _, tc := range []struct {
+		cc     string
+		status int
+	}{
+		{cc: "max-age=0", status: http.StatusOK},
+		{cc: "no-cache", status: http.StatusInternalServerError},
+		{cc: "max-age=0, must-revalidate", status: http.StatusInternalServerError},
+	} {
+		t.Run(tc.cc, func(t *testing.T) {
+			fail.Store(false)
+			h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+				c.Cache.Enabled = lo.ToPtr(true)
+				c.Cache.Mode = lo.ToPtr(types.CacheModeHttp)
+				c.Cache.StaleWhileRevalidate = lo.ToPtr(time.Minute)
+				c.Cache.StaleIfError = lo.ToPtr(time.Minute)
+			})
+			u := srv.URL + "?" + url.Values{"cc": {tc.cc}}.Encode()
+			get(t, h, u)
+			fail.Store(true)
+			if resp, _ := get(t, h, u); resp.StatusCode != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	srv, calls := counting(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("X-Test", "1")
+		if r.URL.Path == "/vary" {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		_, _ = fmt.Fprintf(w, "body %d %s %s", call, r.Header.Get("Accept-Language"), strings.Repeat("x", 100))
+	})
+	dir := filepath.Join(t.TempDir(), "cache")
+	// start creates client backed by disk cache, previous one is closed as if it was shut down
+	start := func(maxBytes int64) *hcServiceImpl {
+		return newTestClient(t, func(c *types.HttpClientServiceConfig) {
+			c.Cache.Enabled = lo.ToPtr(true)
+			c.Cache.Backend = lo.ToPtr(types.CacheBackendDisk)
+			c.Cache.Disk = &types.DiskCacheConfig{Path: lo.ToPtr(dir), MaxBytes: lo.ToPtr(maxBytes)}
+		})
+	}
+	fetch := func(h *hcServiceImpl, path string, hdr ...string) string {
+		t.Helper()
+		resp, body := get(t, h, srv.URL+path, hdr...)
+		if resp.Header.Get("X-Test") != "1" {
+			t.Errorf("response headers must be cached, got %v", resp.Header)
+		}
+		return body[:strings.LastIndex(body, " ")]
+	}
+	files := func() (n int, size int64) {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			fi, _ := e.Info()
+			size += fi.Size()
+		}
+		return len(ents), size
+	}
+
+	h := start(0)
+	fetch(h, "/a")
+	fetch(h, "/b")
+	fetch(h, "/vary", "Accept-Language", "en")
+	fetch(h, "/vary", "Accept-Language", "de")
+	_ = h.Close()
+	if n, _ := files(); n != 4 || calls.Load() != 4 {
+		t.Fatalf("expected 4 entries after 4 calls, got %d entries after %d calls", n, calls.Load())
+	}
+
+	h = start(0)
+	for _, tc := range []struct {
+		path string
+		lang string
+		exp  string
+	}{
+		{path: "/a", exp: "body 1 "},
+		{path: "/b", exp: "body 2 "},
+		{path: "/vary", lang: "de", exp: "body 4 de"},
+		{path: "/vary", lang: "en", exp: "body 3 en"},
+	} {
+		if body := fetch(h, tc.path, "Accept-Language", tc.lang); body != tc.exp {
+			t.Errorf("%s (%s): expected %q to be loaded from disk, got %q", tc.path, tc.lang, tc.exp, body)
+		}
+	}
+	if calls.Load() != 4 {
+		t.Errorf("expected no calls after restart, got %d", calls.Load()-4)
+	}
+	_ = h.Close()
+
+	_, size := files()
+	maxBytes := size/4 + 100
+	h = start(maxBytes)
+	fetch(h, "/c")
+	if n, size := files(); n != 1 || size > maxBytes {
+		t.Errorf("expected entries to be pruned below %d bytes, got %d entries of %d bytes", maxBytes, n, size)
+	}
+	if body := fetch(h, "/c"); body != "body 5 " || calls.Load() != 5 {
+		t.Errorf("entry that expires last must be kept, got %q after %d calls", body, calls.Load())
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+	"golang.org/x/time/rate"
+)
+
+var errThrottled = errors.New("request throttled by rate limit")
+
+// limiter hands out permits to send requests, either per host or for whole client.
+type limiter struct {
+	rate        rate.Limit
+	burst       int
+	maxInFlight int
+	perHost     bool
+	onLimit     types.OnLimitAction
+	maxWait     time.Duration
+	mu          sync.Mutex
+	hosts       map[string]*hostLimiter
+}
+
+type hostLimiter struct {
+	rl  *rate.Limiter
+	sem chan struct{}
+}
+
+func newLimiter(cfg *types.RateLimitConfig) *limiter {
+	l := &limiter{
+		rate:        rate.Inf,
+		burst:       max(lo.FromPtrOr(cfg.Burst, 1), 1),
+		maxInFlight: lo.FromPtrOr(cfg.MaxInFlight, 0),
+		perHost:     lo.FromPtrOr(cfg.PerHost, true),
+		onLimit:     lo.FromPtrOr(cfg.OnLimit, types.OnLimitWait),
+		maxWait:     lo.FromPtrOr(cfg.MaxWait, 0),
+		hosts:       make(map[string]*hostLimiter),
+	}
+	if r := lo.FromPtrOr(cfg.Rate, 0); r > 0 {
+		l.rate = rate.Limit(r)
+	}
+	return l
+}
+
+func (l *limiter) forHost(host string) *hostLimiter {
+	if !l.perHost {
+		host = ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hl, ok := l.hosts[host]
+	if !ok {
+		hl = &hostLimiter{rl: rate.NewLimiter(l.rate, l.burst)}
+		if l.maxInFlight > 0 {
+			hl.sem = make(chan struct{}, l.maxInFlight)
+		}
+		l.hosts[host] = hl
+	}
+	return hl
+}
+
+func (hl *hostLimiter) release() {
+	if hl.sem != nil {
+		<-hl.sem
+	}
+}
+
+// tryAcquire gets permit without waiting.
+func (hl *hostLimiter) tryAcquire() (func(), bool) {
+	r := hl.rl.Reserve()
+	if r.Delay() > 0 {
+		r.Cancel()
+		return nil, false
+	}
+	if hl.sem != nil {
+		select {
+		case hl.sem <- struct{}{}:
+		default:
+			r.Cancel()
+			return nil, false
+		}
+	}
+	return sync.OnceFunc(hl.release), true
+}
+
+// acquire waits for permit until context is done.
+func (hl *hostLimiter) acquire(ctx context.Context) (func(), error) {
+	if hl.sem != nil {
+		select {
+		case hl.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, errThrottled
+		}
+	}
+	if err := hl.rl.Wait(ctx); err != nil {
+		hl.release()
+		return nil, errThrottled
+	}
+	return sync.OnceFunc(hl.release), nil
+}
+
+// releasingBody releases permit once response body is fully read or closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releasingBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.release()
+	}
+	return n, err
+}
+
+func (r *releasingBody) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
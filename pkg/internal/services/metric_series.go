@@ -0,0 +1,157 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+// deletableVec is metric vector whose series can be removed.
+type deletableVec interface {
+	DeleteLabelValues(lvs ...string) bool
+}
+
+// trackedSeries is series of metric, along with target that last updated it.
+type trackedSeries struct {
+	labels  []string
+	target  string
+	touched time.Time
+}
+
+// seriesTracker tracks series of metrics that have ResetEachScrape or ExpireAfter policy,
+// as well as source timestamps of series.
+type seriesTracker struct {
+	mu sync.Mutex
+	// series of every tracked metric, keyed by seriesKey
+	series map[string]map[string]*trackedSeries
+	// timestamps of series of every metric, keyed by seriesKey
+	timestamps map[string]map[string]time.Time
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{
+		series:     make(map[string]map[string]*trackedSeries),
+		timestamps: make(map[string]map[string]time.Time),
+	}
+}
+
+// seriesKey joins label values into key that identifies series within metric.
+func seriesKey(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+func tracked(spec *types.MetricOptsSpec) bool {
+	return lo.FromPtr(spec.ResetEachScrape) || spec.ExpireAfter != nil
+}
+
+func (t *seriesTracker) touch(spec *types.MetricOptsSpec, labels []string, target string) {
+	if !tracked(spec) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ms, ok := t.series[spec.Name]
+	if !ok {
+		ms = make(map[string]*trackedSeries)
+		t.series[spec.Name] = ms
+	}
+	ms[seriesKey(labels)] = &trackedSeries{
+		labels:  slices.Clone(labels),
+		target:  target,
+		touched: time.Now(),
+	}
+}
+
+// begin removes series owned by target from metrics with ResetEachScrape.
+func (t *seriesTracker) begin(target string, mos map[string]*types.MetricOptsSpec) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeIf(mos, func(spec *types.MetricOptsSpec, s *trackedSeries) bool {
+		return lo.FromPtr(spec.ResetEachScrape) && s.target == target
+	})
+}
+
+// expire removes series of metrics with ExpireAfter that weren't updated in time.
+func (t *seriesTracker) expire(mos map[string]*types.MetricOptsSpec) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.removeIf(mos, func(spec *types.MetricOptsSpec, s *trackedSeries) bool {
+		return spec.ExpireAfter != nil && now.Sub(s.touched) > *spec.ExpireAfter
+	})
+}
+
+func (t *seriesTracker) removeIf(mos map[string]*types.MetricOptsSpec, fn func(*types.MetricOptsSpec, *trackedSeries) bool) {
+	for name, ms := range t.series {
+		spec := mos[name]
+		for key, s := range ms {
+			if fn(spec, s) {
+				deleteSeries(spec, s.labels)
+				delete(ms, key)
+				delete(t.timestamps[name], key)
+			}
+		}
+	}
+}
+
+// stamp sets source timestamp of series. Zero timestamp means that series is exported with scrape time.
+func (t *seriesTracker) stamp(spec *types.MetricOptsSpec, labels []string, ts time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	mt, ok := t.timestamps[spec.Name]
+	if ts.IsZero() {
+		if ok {
+			delete(mt, seriesKey(labels))
+		}
+		return
+	}
+	if !ok {
+		mt = make(map[string]time.Time)
+		t.timestamps[spec.Name] = mt
+	}
+	mt[seriesKey(labels)] = ts
+}
+
+// timestampsOf gets copy of source timestamps of metric's series, or nil if there are none.
+func (t *seriesTracker) timestampsOf(name string) map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.timestamps[name]) == 0 {
+		return nil
+	}
+	return maps.Clone(t.timestamps[name])
+}
+
+// deleteSeries removes series with given label values from metric.
+// For stateset, series of all states are removed.
+func deleteSeries(spec *types.MetricOptsSpec, labels []string) {
+	vec := spec.MetricRef.(deletableVec)
+	if *spec.Type == "stateset" {
+		for _, state := range spec.States {
+			vec.DeleteLabelValues(append(slices.Clip(labels), state)...)
+		}
+		return
+	}
+	vec.DeleteLabelValues(labels...)
+}
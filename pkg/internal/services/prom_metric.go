@@ -19,44 +19,68 @@ package services
 import (
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/rkosegi/universal-exporter/pkg/types"
 	"github.com/samber/lo"
 )
 
 func NewMetricService(mos map[string]*types.MetricOptsSpec, l *slog.Logger) types.MetricService {
 	return &promMetricService{
-		mos: mos,
-		l:   l,
+		mos:     mos,
+		l:       l,
+		tracker: newSeriesTracker(),
 	}
 }
 
 type promMetricService struct {
 	*noopService
-	mos map[string]*types.MetricOptsSpec
-	l   *slog.Logger
+	mos     map[string]*types.MetricOptsSpec
+	l       *slog.Logger
+	tracker *seriesTracker
 }
 
 func (ms *promMetricService) Describe(ch chan<- *prometheus.Desc) {
 	for _, m := range ms.mos {
-		switch *m.Type {
-		case "counter":
-			m.MetricRef.(*prometheus.CounterVec).Describe(ch)
-		case "gauge":
-			m.MetricRef.(*prometheus.GaugeVec).Describe(ch)
-		}
+		m.MetricRef.(prometheus.Collector).Describe(ch)
 	}
 }
 
 func (ms *promMetricService) Collect(ch chan<- prometheus.Metric) {
+	ms.tracker.expire(ms.mos)
 	for _, m := range ms.mos {
-		switch *m.Type {
-		case "counter":
-			m.MetricRef.(*prometheus.CounterVec).Collect(ch)
-		case "gauge":
-			m.MetricRef.(*prometheus.GaugeVec).Collect(ch)
+		if ts := ms.tracker.timestampsOf(m.Name); ts != nil {
+			collectWithTimestamps(m, ts, ch)
+		} else {
+			m.MetricRef.(prometheus.Collector).Collect(ch)
+		}
+	}
+}
+
+// collectWithTimestamps collects metric, attaching source timestamps to series that have one.
+func collectWithTimestamps(m *types.MetricOptsSpec, ts map[string]time.Time, ch chan<- prometheus.Metric) {
+	mch := make(chan prometheus.Metric)
+	go func() {
+		m.MetricRef.(prometheus.Collector).Collect(mch)
+		close(mch)
+	}()
+	for metric := range mch {
+		var pb dto.Metric
+		if err := metric.Write(&pb); err == nil {
+			values := lo.SliceToMap(pb.GetLabel(), func(lp *dto.LabelPair) (string, string) {
+				return lp.GetName(), lp.GetValue()
+			})
+			labels := lo.Map(m.Labels, func(name string, _ int) string {
+				return values[name]
+			})
+			if t, ok := ts[seriesKey(labels)]; ok {
+				metric = prometheus.NewMetricWithTimestamp(t, metric)
+			}
 		}
+		ch <- metric
 	}
 }
 
@@ -71,7 +95,33 @@ func (ms *promMetricService) Start() error {
 		case "gauge":
 			opt.MetricRef = prometheus.NewGaugeVec(prometheus.GaugeOpts(opt.AsOpts()), opt.Labels)
 		case "counter":
-			opt.MetricRef = prometheus.NewCounterVec(prometheus.CounterOpts(opt.AsOpts()), opt.Labels)
+			switch mode := lo.FromPtrOr(opt.CounterMode, types.CounterModeIncrement); mode {
+			case types.CounterModeIncrement:
+				opt.MetricRef = prometheus.NewCounterVec(prometheus.CounterOpts(opt.AsOpts()), opt.Labels)
+			case types.CounterModeAbsolute:
+				opt.MetricRef = newAbsoluteCounterVec(opt)
+			default:
+				return fmt.Errorf("metric %s: unknown counter mode: %s", name, mode)
+			}
+		case "histogram":
+			ho, err := histogramOpts(opt)
+			if err != nil {
+				return fmt.Errorf("metric %s: %w", name, err)
+			}
+			opt.MetricRef = prometheus.NewHistogramVec(ho, opt.Labels)
+		case "summary":
+			opt.MetricRef = prometheus.NewSummaryVec(summaryOpts(opt), opt.Labels)
+		case "info":
+			opt.MetricRef = prometheus.NewGaugeVec(prometheus.GaugeOpts(opt.AsOpts()), opt.Labels)
+		case "stateset":
+			if len(opt.States) == 0 {
+				return fmt.Errorf("metric %s: stateset requires at least one state", name)
+			}
+			stateLabel := lo.FromPtrOr(opt.StateLabel, types.DefaultStateLabel)
+			if lo.Contains(opt.Labels, stateLabel) {
+				return fmt.Errorf("metric %s: state label '%s' conflicts with labels", name, stateLabel)
+			}
+			opt.MetricRef = prometheus.NewGaugeVec(prometheus.GaugeOpts(opt.AsOpts()), append(slices.Clone(opt.Labels), stateLabel))
 		default:
 			return fmt.Errorf("unsupported metric type: %s", *opt.Type)
 		}
@@ -79,6 +129,47 @@ func (ms *promMetricService) Start() error {
 	return nil
 }
 
+func histogramOpts(opt *types.MetricOptsSpec) (prometheus.HistogramOpts, error) {
+	o := opt.AsOpts()
+	ho := prometheus.HistogramOpts{
+		Name:        o.Name,
+		Help:        o.Help,
+		ConstLabels: o.ConstLabels,
+	}
+	if opt.Buckets != nil {
+		var err error
+		if ho.Buckets, err = opt.Buckets.Bounds(); err != nil {
+			return ho, err
+		}
+	}
+	if n := opt.Native; n != nil {
+		if n.BucketFactor <= 1 {
+			return ho, fmt.Errorf("bucket factor of native histogram must be greater than 1")
+		}
+		ho.NativeHistogramBucketFactor = n.BucketFactor
+		ho.NativeHistogramZeroThreshold = lo.FromPtr(n.ZeroThreshold)
+		ho.NativeHistogramMaxBucketNumber = lo.FromPtr(n.MaxBucketNumber)
+		ho.NativeHistogramMinResetDuration = lo.FromPtr(n.MinResetDuration)
+	}
+	return ho, nil
+}
+
+func summaryOpts(opt *types.MetricOptsSpec) prometheus.SummaryOpts {
+	o := opt.AsOpts()
+	so := prometheus.SummaryOpts{
+		Name:        o.Name,
+		Help:        o.Help,
+		ConstLabels: o.ConstLabels,
+		MaxAge:      lo.FromPtr(opt.MaxAge),
+	}
+	if len(opt.Objectives) > 0 {
+		so.Objectives = lo.SliceToMap(opt.Objectives, func(o types.SummaryObjective) (float64, float64) {
+			return o.Quantile, o.Error
+		})
+	}
+	return so
+}
+
 func (ms *promMetricService) GetRef(name string) (*types.MetricOptsSpec, error) {
 	if opt, ok := ms.mos[name]; !ok {
 		return nil, fmt.Errorf("no such metric: '%s'", name)
@@ -86,3 +177,28 @@ func (ms *promMetricService) GetRef(name string) (*types.MetricOptsSpec, error)
 		return opt, nil
 	}
 }
+
+func (ms *promMetricService) BeginTarget(target string) types.MetricService {
+	ms.tracker.begin(target, ms.mos)
+	return &targetMetricService{promMetricService: ms, target: target}
+}
+
+func (ms *promMetricService) SetTimestamp(spec *types.MetricOptsSpec, labels []string, ts time.Time) {
+	ms.tracker.stamp(spec, labels, ts)
+}
+
+// Touch records update of series that isn't owned by any target.
+func (ms *promMetricService) Touch(spec *types.MetricOptsSpec, labels []string) {
+	ms.tracker.touch(spec, labels, "")
+}
+
+// targetMetricService is MetricService used by pipeline of single target.
+// Target is bound to it, so that pipelines of concurrent scrapes can run in parallel.
+type targetMetricService struct {
+	*promMetricService
+	target string
+}
+
+func (ts *targetMetricService) Touch(spec *types.MetricOptsSpec, labels []string) {
+	ts.tracker.touch(spec, labels, ts.target)
+}
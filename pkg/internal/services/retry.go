@@ -0,0 +1,99 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+// retryPolicy decides whether and when is failed request retried.
+type retryPolicy struct {
+	maxAttempts int
+	statusCodes map[int]bool
+	base        time.Duration
+	max         time.Duration
+	jitter      float64
+	retryAfter  bool
+	methods     map[string]bool
+}
+
+func newRetryPolicy(cfg *types.RetryConfig) *retryPolicy {
+	codes := cfg.StatusCodes
+	if len(codes) == 0 {
+		codes = types.DefaultRetryStatusCodes
+	}
+	return &retryPolicy{
+		maxAttempts: lo.FromPtrOr(cfg.MaxAttempts, types.DefaultRetryMaxAttempts),
+		statusCodes: lo.SliceToMap(codes, func(c int) (int, bool) { return c, true }),
+		base:        lo.FromPtrOr(cfg.BaseBackoff, types.DefaultRetryBaseBackoff),
+		max:         lo.FromPtrOr(cfg.MaxBackoff, types.DefaultRetryMaxBackoff),
+		jitter:      lo.Clamp(lo.FromPtrOr(cfg.Jitter, types.DefaultRetryJitter), 0, 1),
+		retryAfter:  lo.FromPtrOr(cfg.RetryAfter, true),
+		methods: lo.SliceToMap(lo.Ternary(len(cfg.Methods) > 0, cfg.Methods, types.DefaultRetryMethods),
+			func(m string) (string, bool) {
+				return strings.ToUpper(m), true
+			}),
+	}
+}
+
+// shouldRetry checks whether outcome of an attempt is worth retrying.
+func (p *retryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// throttling and open circuit are decided elsewhere
+		return !errors.Is(err, errThrottled) && !errors.Is(err, errCircuitOpen)
+	}
+	return p.statusCodes[resp.StatusCode]
+}
+
+// backoff computes delay before next attempt, given number of attempts made so far.
+func (p *retryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if p.retryAfter && resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+	d := p.base << (attempt - 1)
+	if d <= 0 || d > p.max {
+		d = p.max
+	}
+	if p.jitter > 0 {
+		d = time.Duration(float64(d) * (1 - p.jitter*rand.Float64()))
+	}
+	return d
+}
+
+// parseRetryAfter parses value of Retry-After header, which is either number of seconds or HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+)
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+func newTLSConfig(cfg *types.TLSConfig) (*tls.Config, error) {
+	tc := &tls.Config{}
+	if cfg.ServerName != nil {
+		tc.ServerName = *cfg.ServerName
+	}
+	if cfg.InsecureSkipVerify != nil {
+		tc.InsecureSkipVerify = *cfg.InsecureSkipVerify
+	}
+	if cfg.MinVersion != nil {
+		if v, ok := tlsVersions[strings.ToUpper(*cfg.MinVersion)]; !ok {
+			return nil, fmt.Errorf("unknown TLS version: %s", *cfg.MinVersion)
+		} else {
+			tc.MinVersion = v
+		}
+	}
+	if cfg.CaFile != nil {
+		data, err := os.ReadFile(*cfg.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", *cfg.CaFile)
+		}
+	}
+	if (cfg.CertFile == nil) != (cfg.KeyFile == nil) {
+		return nil, errors.New("both certFile and keyFile must be set")
+	}
+	if cfg.CertFile != nil {
+		cert, err := tls.LoadX509KeyPair(*cfg.CertFile, *cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// tlsRoundTripper creates new http.RoundTripper whenever any of configured certificate files changes.
+type tlsRoundTripper struct {
+	cfg   *types.TLSConfig
+	newRT func(*tls.Config) http.RoundTripper
+	l     *slog.Logger
+	mu    sync.RWMutex
+	rt    http.RoundTripper
+	fp    string
+}
+
+// fingerprint computes cheap fingerprint of certificate files from their size and modification time.
+func (t *tlsRoundTripper) fingerprint() (string, error) {
+	var sb strings.Builder
+	for _, f := range []*string{t.cfg.CaFile, t.cfg.CertFile, t.cfg.KeyFile} {
+		if f == nil {
+			continue
+		}
+		fi, err := os.Stat(*f)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(&sb, "%s:%d:%d;", *f, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return sb.String(), nil
+}
+
+// reload gets round tripper for current certificate files. When files can't be loaded, such as while they are
+// being rotated, previous round tripper is kept, so only initial load can fail.
+func (t *tlsRoundTripper) reload() (http.RoundTripper, error) {
+	rt, err := t.load()
+	if err != nil {
+		t.mu.RLock()
+		rt = t.rt
+		t.mu.RUnlock()
+		if rt == nil {
+			return nil, err
+		}
+		t.l.Warn("unable to reload TLS configuration, using previous one", "err", err.Error())
+	}
+	return rt, nil
+}
+
+func (t *tlsRoundTripper) load() (http.RoundTripper, error) {
+	fp, err := t.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	rt := t.rt
+	same := rt != nil && fp == t.fp
+	t.mu.RUnlock()
+	if same {
+		return rt, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rt != nil && fp == t.fp {
+		return t.rt, nil
+	}
+	tc, err := newTLSConfig(t.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if ci, ok := t.rt.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+	t.rt = t.newRT(tc)
+	t.fp = fp
+	return t.rt, nil
+}
+
+func (t *tlsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, err := t.reload()
+	if err != nil {
+		return nil, err
+	}
+	return rt.RoundTrip(req)
+}
+
+func newTLSRoundTripper(cfg *types.TLSConfig, newRT func(*tls.Config) http.RoundTripper, l *slog.Logger) (http.RoundTripper, error) {
+	t := &tlsRoundTripper{cfg: cfg, newRT: newRT, l: l}
+	// fail early on invalid configuration
+	if _, err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// authRoundTripper applies authentication to requests that don't have any yet.
+// This allows http_fetch to override authentication on per-request basis.
+type authRoundTripper struct {
+	apply func(*http.Request) error
+	next  http.RoundTripper
+}
+
+func (a *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) > 0 {
+		return a.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if err := a.apply(req); err != nil {
+		return nil, err
+	}
+	return a.next.RoundTrip(req)
+}
+
+// clientCredentialsTokenSource obtains tokens using client credentials flow.
+// Client secret is read whenever new token is requested, so that rotated secret is picked up.
+type clientCredentialsTokenSource struct {
+	cfg    *types.OAuth2Config
+	params url.Values
+	ctx    context.Context
+}
+
+func (s *clientCredentialsTokenSource) Token() (*oauth2.Token, error) {
+	secret, err := s.cfg.ClientSecretValue()
+	if err != nil {
+		return nil, err
+	}
+	cc := &clientcredentials.Config{
+		ClientID:       s.cfg.ClientId,
+		ClientSecret:   secret,
+		TokenURL:       s.cfg.TokenUrl,
+		Scopes:         s.cfg.Scopes,
+		EndpointParams: s.params,
+	}
+	return cc.Token(s.ctx)
+}
+
+// newOAuth2TokenSource creates token source for client credentials flow.
+// Token requests are sent using provided http.RoundTripper and are subject to given timeout.
+// Tokens are reused until they expire.
+func newOAuth2TokenSource(cfg *types.OAuth2Config, rt http.RoundTripper, timeout time.Duration) (oauth2.TokenSource, error) {
+	// fail early when secret can't be read
+	if _, err := cfg.ClientSecretValue(); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	for k, v := range cfg.EndpointParams {
+		params.Set(k, v)
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: rt, Timeout: timeout})
+	return oauth2.ReuseTokenSource(nil, &clientCredentialsTokenSource{cfg: cfg, params: params, ctx: ctx}), nil
+}
+
+// newProxyFunc creates function that determines proxy to use for given request.
+func newProxyFunc(cfg *types.ProxyConfig) func(*http.Request) (*url.URL, error) {
+	pc := &httpproxy.Config{}
+	if cfg.FromEnvironment != nil && *cfg.FromEnvironment {
+		pc = httpproxy.FromEnvironment()
+	} else if cfg.Url != nil {
+		pc.HTTPProxy = *cfg.Url
+		pc.HTTPSProxy = *cfg.Url
+	}
+	if len(cfg.NoProxy) > 0 {
+		noProxy := cfg.NoProxy
+		if len(pc.NoProxy) > 0 {
+			noProxy = append([]string{pc.NoProxy}, noProxy...)
+		}
+		pc.NoProxy = strings.Join(noProxy, ",")
+	}
+	fn := pc.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}
+}
+
+// newBaseTransportFn creates function that creates http.Transport with given TLS configuration.
+func newBaseTransportFn(cfg *types.HttpClientServiceConfig) func(*tls.Config) http.RoundTripper {
+	return func(tc *tls.Config) http.RoundTripper {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tc
+		if cfg.Proxy != nil {
+			t.Proxy = newProxyFunc(cfg.Proxy)
+			if len(cfg.Proxy.ConnectHeaders) > 0 {
+				t.ProxyConnectHeader = http.Header{}
+				for k, v := range cfg.Proxy.ConnectHeaders {
+					t.ProxyConnectHeader.Set(k, v)
+				}
+			}
+		}
+		return t
+	}
+}
+
+// newTransport creates http.RoundTripper according to configuration.
+func newTransport(cfg *types.HttpClientServiceConfig, l *slog.Logger) (http.RoundTripper, error) {
+	var (
+		rt     = http.DefaultTransport
+		err    error
+		baseFn = newBaseTransportFn(cfg)
+	)
+	if cfg.TLS != nil {
+		if rt, err = newTLSRoundTripper(cfg.TLS, baseFn, l); err != nil {
+			return nil, err
+		}
+	} else if cfg.Proxy != nil {
+		rt = baseFn(nil)
+	}
+	if cfg.OAuth2 != nil {
+		ts, err := newOAuth2TokenSource(cfg.OAuth2, rt, lo.FromPtr(cfg.Timeout))
+		if err != nil {
+			return nil, err
+		}
+		rt = &authRoundTripper{apply: func(req *http.Request) error {
+			tok, err := ts.Token()
+			if err != nil {
+				return err
+			}
+			tok.SetAuthHeader(req)
+			return nil
+		}, next: rt}
+	} else if cfg.Auth != nil {
+		rt = &authRoundTripper{apply: cfg.Auth.Apply, next: rt}
+	}
+	return rt, nil
+}
@@ -0,0 +1,391 @@
+/*
+Copyright 2025 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rkosegi/universal-exporter/pkg/types"
+	"github.com/samber/lo"
+)
+
+// authEcho responds with Authorization header of request.
+func authEcho(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+}
+
+func TestAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(authEcho))
+	defer srv.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Auth = &types.AuthConfig{Bearer: &types.BearerAuthConfig{TokenFile: lo.ToPtr(tokenFile)}}
+	})
+	if _, body := get(t, h, srv.URL); body != "Bearer secret1" {
+		t.Errorf("unexpected authorization %q", body)
+	}
+	if err := os.WriteFile(tokenFile, []byte("secret2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := get(t, h, srv.URL); body != "Bearer secret2" {
+		t.Errorf("rotated token not picked up, got %q", body)
+	}
+	if _, body := get(t, h, srv.URL, "Authorization", "Custom x"); body != "Custom x" {
+		t.Errorf("authorization of request must not be overridden, got %q", body)
+	}
+
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Auth = &types.AuthConfig{Basic: &types.BasicAuthConfig{Username: lo.ToPtr("u"), PasswordFile: lo.ToPtr(tokenFile)}}
+	})
+	if _, body := get(t, h, srv.URL); body != "Basic dTpzZWNyZXQy" {
+		t.Errorf("unexpected authorization %q", body)
+	}
+
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Auth = &types.AuthConfig{Bearer: &types.BearerAuthConfig{TokenFile: lo.ToPtr(tokenFile + ".missing")}}
+	})
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error for missing token file")
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_ = r.ParseForm()
+		_, secret, _ := r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"tok%d-%s-%s-%s","token_type":"bearer","expires_in":%s}`,
+			calls, secret, r.Form.Get("scope"), r.Form.Get("audience"), r.URL.Query().Get("expires"))
+	}))
+	defer ts.Close()
+	api := httptest.NewServer(http.HandlerFunc(authEcho))
+	defer api.Close()
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("s1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.OAuth2 = &types.OAuth2Config{TokenUrl: ts.URL + "?expires=3600", ClientId: "id", ClientSecretFile: lo.ToPtr(secretFile),
+			Scopes: []string{"a", "b"}, EndpointParams: map[string]string{"audience": "aud"}}
+	})
+	for i := 0; i < 2; i++ {
+		if _, body := get(t, h, api.URL); body != "Bearer tok1-s1-a b-aud" {
+			t.Errorf("unexpected authorization %q", body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("token must be reused until it expires, got %d token requests", calls)
+	}
+
+	// token expires immediately, so new one is requested with rotated secret
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.OAuth2 = &types.OAuth2Config{TokenUrl: ts.URL + "?expires=1", ClientId: "id", ClientSecretFile: lo.ToPtr(secretFile)}
+	})
+	if _, body := get(t, h, api.URL); body != "Bearer tok2-s1--" {
+		t.Errorf("unexpected authorization %q", body)
+	}
+	if err := os.WriteFile(secretFile, []byte("s2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := get(t, h, api.URL); body != "Bearer tok3-s2--" {
+		t.Errorf("rotated secret not picked up, got %q", body)
+	}
+}
+
+func TestOAuth2Timeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Timeout = lo.ToPtr(100 * time.Millisecond)
+		c.OAuth2 = &types.OAuth2Config{TokenUrl: ts.URL, ClientId: "id", ClientSecret: lo.ToPtr("s")}
+	})
+	start := time.Now()
+	if _, _, err := send(t, h, http.MethodGet, "http://127.0.0.1:1", nil); err == nil {
+		t.Error("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("token request must time out, took %v", elapsed)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.ServerName))
+	}))
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestClient(t, nil)
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error for unknown CA")
+	}
+
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{InsecureSkipVerify: lo.ToPtr(true), MinVersion: lo.ToPtr("TLS12")}
+	})
+	get(t, h, srv.URL)
+
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{CaFile: lo.ToPtr(caFile), ServerName: lo.ToPtr("example.com")}
+	})
+	if _, body := get(t, h, srv.URL); body != "example.com" {
+		t.Errorf("unexpected server name %q", body)
+	}
+
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{CaFile: lo.ToPtr(caFile), ServerName: lo.ToPtr("other.org")}
+	})
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error for mismatched server name")
+	}
+}
+
+// testCA is certificate authority that issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	ca := &testCA{}
+	ca.cert, ca.key, ca.pem = newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	return ca
+}
+
+// issue issues certificate for server at 127.0.0.1 and client with given name,
+// returning it along with PEM encoded certificate and key.
+func (ca *testCA) issue(t *testing.T, name string) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+	_, key, certPEM := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca.cert, ca.key)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certPEM, keyPEM
+}
+
+// newCert creates certificate from template, signed by parent. Certificate is self-signed when parent is nil.
+func newCert(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeFile writes file, failing test on error.
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSServer starts TLS server that presents certificate returned by getCert and responds with common name
+// of client certificate, if there is any. Keep-alive is disabled, so that every request performs handshake.
+func newTLSServer(t *testing.T, getCert func() *tls.Certificate, clientCA *testCA) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	// certificate of httptest is used for clients without SNI unless config is provided per client
+	srv.TLS = &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		tc := &tls.Config{Certificates: []tls.Certificate{*getCert()}}
+		if clientCA != nil {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+			tc.ClientCAs = x509.NewCertPool()
+			tc.ClientCAs.AddCert(clientCA.cert)
+		}
+		return tc, nil
+	}}
+	srv.Config.SetKeepAlivesEnabled(false)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTLSReload(t *testing.T) {
+	ca1, ca2 := newTestCA(t, "ca1"), newTestCA(t, "ca2")
+	cert1, _, _ := ca1.issue(t, "server")
+	cert2, _, _ := ca2.issue(t, "server")
+	var current atomic.Pointer[tls.Certificate]
+	current.Store(&cert1)
+	srv := newTLSServer(t, current.Load, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caFile, ca1.pem)
+
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{CaFile: lo.ToPtr(caFile)}
+	})
+	get(t, h, srv.URL)
+	current.Store(&cert2)
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error for certificate issued by CA that isn't trusted yet")
+	}
+	writeFile(t, caFile, ca2.pem)
+	get(t, h, srv.URL)
+
+	// partially written file is ignored, previous CA is used until valid one is written
+	writeFile(t, caFile, ca1.pem[:20])
+	get(t, h, srv.URL)
+	if err := os.Remove(caFile); err != nil {
+		t.Fatal(err)
+	}
+	get(t, h, srv.URL)
+	writeFile(t, caFile, ca1.pem)
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error once rotated CA no longer trusts server")
+	}
+
+	_ = os.Remove(caFile)
+	if _, err := startTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{CaFile: lo.ToPtr(caFile)}
+	}); err == nil {
+		t.Error("expected error when CA file is missing on start")
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	serverCert, _, _ := ca.issue(t, "server")
+	srv := newTLSServer(t, func() *tls.Certificate { return &serverCert }, ca)
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(t, caFile, ca.pem)
+	_, certPEM, keyPEM := ca.issue(t, "client1")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{CaFile: lo.ToPtr(caFile)}
+	})
+	if _, _, err := send(t, h, http.MethodGet, srv.URL, nil); err == nil {
+		t.Error("expected error without client certificate")
+	}
+
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{CaFile: lo.ToPtr(caFile), CertFile: lo.ToPtr(certFile), KeyFile: lo.ToPtr(keyFile)}
+	})
+	if _, body := get(t, h, srv.URL); body != "client1" {
+		t.Errorf("unexpected client certificate %q", body)
+	}
+	_, certPEM, keyPEM = ca.issue(t, "client2")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	if _, body := get(t, h, srv.URL); body != "client2" {
+		t.Errorf("rotated client certificate not picked up, got %q", body)
+	}
+
+	if _, err := startTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.TLS = &types.TLSConfig{CertFile: lo.ToPtr(certFile)}
+	}); err == nil {
+		t.Error("expected error when key file is not set")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("proxy " + r.URL.String()))
+	}))
+	defer proxy.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("direct"))
+	}))
+	defer direct.Close()
+
+	h := newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Proxy = &types.ProxyConfig{Url: lo.ToPtr(proxy.URL), NoProxy: []string{"127.0.0.1"}}
+	})
+	if _, body := get(t, h, "http://target.example.invalid/x"); body != "proxy http://target.example.invalid/x" {
+		t.Errorf("request must go through proxy, got %q", body)
+	}
+	if _, body := get(t, h, direct.URL); body != "direct" {
+		t.Errorf("request to host in noProxy must go directly, got %q", body)
+	}
+
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	h = newTestClient(t, func(c *types.HttpClientServiceConfig) {
+		c.Proxy = &types.ProxyConfig{FromEnvironment: lo.ToPtr(true)}
+	})
+	if _, body := get(t, h, "http://env.example.invalid/y"); body != "proxy http://env.example.invalid/y" {
+		t.Errorf("request must go through proxy from environment, got %q", body)
+	}
+}
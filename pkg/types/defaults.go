@@ -19,10 +19,39 @@ package types
 import "time"
 
 const (
-	PromNamespace                = "uni"
-	DefaultHealthEndpoint        = "/healthz"
-	DefaultMetricsEndpoint       = "/metrics"
-	DefaultMetricPrefixHttpCache = "uni_http_resp_cache"
-	DefaultCacheTTL              = time.Minute * 15
-	DefaultCacheCapacity         = 10
+	PromNamespace                  = "uni"
+	DefaultHealthEndpoint          = "/healthz"
+	DefaultMetricsEndpoint         = "/metrics"
+	DefaultAdminPath               = "/admin"
+	DefaultMetricPrefixHttpCache   = "uni_http_resp_cache"
+	DefaultCacheTTL                = time.Minute * 15
+	DefaultCacheCapacity           = 10
+	DefaultHttpClientName          = "default"
+	HttpClientServiceName          = "HttpClient"
+	DefaultRetryMaxAttempts        = 3
+	DefaultRetryBaseBackoff        = time.Millisecond * 200
+	DefaultRetryMaxBackoff         = time.Second * 5
+	DefaultRetryJitter             = 0.2
+	DefaultCircuitFailureThreshold = 5
+	DefaultCircuitCoolDown         = time.Second * 30
+	DefaultStaleMaxAge             = time.Minute * 5
+	DefaultStateLabel              = "state"
+	// StaleHeader is internal header that marks stale response served by HTTP client service.
+	// It's never taken from server and it's removed when response is parsed.
+	StaleHeader = "X-Uni-Stale"
 )
+
+var (
+	DefaultRetryStatusCodes = []int{429, 502, 503, 504}
+	DefaultCacheMethods     = []string{"GET", "HEAD"}
+	DefaultRetryMethods     = []string{"GET", "HEAD", "OPTIONS", "PUT", "DELETE"}
+	DefaultCacheVaryHeaders = []string{"Authorization", "Accept"}
+)
+
+// HttpClientServiceNameFor gets name under which HttpClientService of given client profile is registered.
+func HttpClientServiceNameFor(client string) string {
+	if len(client) == 0 || client == DefaultHttpClientName {
+		return HttpClientServiceName
+	}
+	return HttpClientServiceName + ":" + client
+}
@@ -18,6 +18,7 @@ package types
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rkosegi/yaml-pipeline/pkg/pipeline"
@@ -28,6 +29,10 @@ type HttpClientService interface {
 	prometheus.Collector
 	RoundTripper() http.RoundTripper
 	Start() error
+	// CacheEntries lists entries of response cache.
+	CacheEntries() []CacheEntry
+	// PurgeCache removes entries of response cache whose key matches and returns number of removed entries.
+	PurgeCache(match func(key string) bool) int
 }
 
 // MetricService provides access to configured metrics
@@ -36,4 +41,20 @@ type MetricService interface {
 	prometheus.Collector
 	GetRef(ref string) (*MetricOptsSpec, error)
 	Start() error
+	// BeginTarget marks start of target's pipeline and returns service to be used by that pipeline.
+	// Series touched through returned service are owned by target.
+	// Series of metrics with ResetEachScrape that are owned by target are removed.
+	BeginTarget(target string) MetricService
+	// Touch records that series of metric with given label values was updated.
+	Touch(spec *MetricOptsSpec, labels []string)
+	// SetTimestamp sets source timestamp with which series is exported.
+	// Zero timestamp means that series is exported with scrape time.
+	SetTimestamp(spec *MetricOptsSpec, labels []string, ts time.Time)
+}
+
+// AbsoluteCounterVec is vector of counters that are set to absolute value of upstream counters.
+type AbsoluteCounterVec interface {
+	prometheus.Collector
+	// Set sets counter with given label values to value of upstream counter.
+	Set(val float64, labels ...string) error
 }
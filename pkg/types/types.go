@@ -18,8 +18,14 @@ package types
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -36,13 +42,103 @@ type MetricOptsSpec struct {
 	Labels []string `json:"labels" yaml:"labels"`
 	// ConstLabels are fixed labels with their values that will be attached to metric
 	ConstLabels map[string]string `json:"constLabels" yaml:"constLabels"`
-	// Metric type. Currently only "gauge" and "counter" are supported.
+	// Metric type, one of "gauge", "counter", "histogram", "summary", "info" or "stateset".
 	// Default value is "gauge".
 	Type *string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Buckets configures buckets of histogram. Default buckets are used when not set.
+	Buckets *BucketsSpec `json:"buckets,omitempty" yaml:"buckets,omitempty"`
+	// Native configures native (sparse) buckets of histogram, in addition to classic ones.
+	Native *NativeHistogramSpec `json:"native,omitempty" yaml:"native,omitempty"`
+	// Objectives are quantiles of summary with their allowed absolute error.
+	Objectives []SummaryObjective `json:"objectives,omitempty" yaml:"objectives,omitempty"`
+	// MaxAge is duration for which observations are kept by summary.
+	MaxAge *time.Duration `json:"maxAge,omitempty" yaml:"maxAge,omitempty"`
+	// States are all possible states of stateset.
+	States []string `json:"states,omitempty" yaml:"states,omitempty"`
+	// CounterMode determines how counter is updated. Default value is "increment".
+	CounterMode *CounterMode `json:"counterMode,omitempty" yaml:"counterMode,omitempty"`
+	// StateLabel is name of label that holds state of stateset. Default value is "state".
+	StateLabel *string `json:"stateLabel,omitempty" yaml:"stateLabel,omitempty"`
+	// ResetEachScrape removes all series of metric set by target before that target runs again,
+	// so that only series set during last run of target are exported.
+	ResetEachScrape *bool `json:"resetEachScrape,omitempty" yaml:"resetEachScrape,omitempty"`
+	// ExpireAfter removes series of metric that weren't updated for given duration.
+	ExpireAfter *time.Duration `json:"expireAfter,omitempty" yaml:"expireAfter,omitempty"`
 	// Metric holds native value
 	MetricRef interface{} `json:"-" yaml:"-"`
 }
 
+// CounterMode determines how counter is updated.
+type CounterMode string
+
+const (
+	// CounterModeIncrement increments counter by given value.
+	CounterModeIncrement = CounterMode("increment")
+	// CounterModeAbsolute sets counter to absolute value of upstream counter, such as requests_total exposed by API.
+	// Decrease of upstream value is treated as reset of upstream counter, so exported counter never decreases.
+	CounterModeAbsolute = CounterMode("absolute")
+)
+
+// BucketsSpec configures buckets of histogram. Exactly one of Values, Linear or Exponential should be set.
+type BucketsSpec struct {
+	// Values are upper bounds of buckets
+	Values []float64 `json:"values,omitempty" yaml:"values,omitempty"`
+	// Linear generates buckets with upper bounds that grow by Width
+	Linear *BucketsGeneratorSpec `json:"linear,omitempty" yaml:"linear,omitempty"`
+	// Exponential generates buckets with upper bounds that grow by Factor
+	Exponential *BucketsGeneratorSpec `json:"exponential,omitempty" yaml:"exponential,omitempty"`
+}
+
+// BucketsGeneratorSpec configures generator of histogram buckets.
+type BucketsGeneratorSpec struct {
+	// Start is upper bound of first bucket
+	Start float64 `json:"start" yaml:"start"`
+	// Width is difference between upper bounds of subsequent buckets, used by linear generator
+	Width float64 `json:"width,omitempty" yaml:"width,omitempty"`
+	// Factor is ratio between upper bounds of subsequent buckets, used by exponential generator
+	Factor float64 `json:"factor,omitempty" yaml:"factor,omitempty"`
+	// Count is number of generated buckets
+	Count int `json:"count" yaml:"count"`
+}
+
+// Bounds computes upper bounds of buckets.
+func (b *BucketsSpec) Bounds() ([]float64, error) {
+	switch {
+	case len(b.Values) > 0:
+		return b.Values, nil
+	case b.Linear != nil:
+		if b.Linear.Count < 1 || b.Linear.Width <= 0 {
+			return nil, errors.New("linear buckets require positive count and width")
+		}
+		return prometheus.LinearBuckets(b.Linear.Start, b.Linear.Width, b.Linear.Count), nil
+	case b.Exponential != nil:
+		if b.Exponential.Count < 1 || b.Exponential.Start <= 0 || b.Exponential.Factor <= 1 {
+			return nil, errors.New("exponential buckets require positive count and start and factor greater than 1")
+		}
+		return prometheus.ExponentialBuckets(b.Exponential.Start, b.Exponential.Factor, b.Exponential.Count), nil
+	default:
+		return prometheus.DefBuckets, nil
+	}
+}
+
+// NativeHistogramSpec configures native histogram.
+type NativeHistogramSpec struct {
+	// BucketFactor is maximal ratio between upper bounds of subsequent buckets, must be greater than 1.
+	BucketFactor float64 `json:"bucketFactor" yaml:"bucketFactor"`
+	// ZeroThreshold is width of zero bucket
+	ZeroThreshold *float64 `json:"zeroThreshold,omitempty" yaml:"zeroThreshold,omitempty"`
+	// MaxBucketNumber is maximum number of buckets, zero means no limit.
+	MaxBucketNumber *uint32 `json:"maxBucketNumber,omitempty" yaml:"maxBucketNumber,omitempty"`
+	// MinResetDuration is minimal time between resets of histogram, when MaxBucketNumber is exceeded.
+	MinResetDuration *time.Duration `json:"minResetDuration,omitempty" yaml:"minResetDuration,omitempty"`
+}
+
+// SummaryObjective is quantile of summary with its allowed absolute error.
+type SummaryObjective struct {
+	Quantile float64 `json:"quantile" yaml:"quantile"`
+	Error    float64 `json:"error" yaml:"error"`
+}
+
 func (p *MetricOptsSpec) AsOpts() prometheus.Opts {
 	return prometheus.Opts{
 		Name:        p.Name,
@@ -69,6 +165,264 @@ type HttpClientServiceConfig struct {
 
 	// Cache configures HTTP response cache
 	Cache *CacheConfig `json:"cache" yaml:"cache"`
+
+	// Auth configures authentication of outgoing requests
+	Auth *AuthConfig `json:"auth,omitempty" yaml:"auth,omitempty"`
+
+	// OAuth2 configures OAuth2 client credentials flow for outgoing requests
+	OAuth2 *OAuth2Config `json:"oauth2,omitempty" yaml:"oauth2,omitempty"`
+
+	// TLS configures TLS settings of outgoing connections
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+
+	// Proxy configures proxy for outgoing requests
+	Proxy *ProxyConfig `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+
+	// Retry configures retry of failed requests. Requests are not retried when not set.
+	Retry *RetryConfig `json:"retry,omitempty" yaml:"retry,omitempty"`
+
+	// RateLimit configures rate and concurrency limits of outgoing requests. Requests are not limited when not set.
+	RateLimit *RateLimitConfig `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
+
+	// CircuitBreaker configures per-host circuit breaker. Circuit breaker is disabled when not set.
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker,omitempty" yaml:"circuitBreaker,omitempty"`
+}
+
+// CircuitBreakerConfig configures circuit breaker, which stops sending requests to failing host for a while.
+// Transport errors and 5xx responses are considered failures.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is number of consecutive failures that opens circuit.
+	FailureThreshold *int `json:"failureThreshold,omitempty" yaml:"failureThreshold,omitempty"`
+
+	// CoolDown is time for which circuit stays open, before probe request is let through.
+	CoolDown *time.Duration `json:"coolDown,omitempty" yaml:"coolDown,omitempty"`
+
+	// ServeStale flag to serve last successful response of same request while circuit is open, instead of failing.
+	ServeStale *bool `json:"serveStale,omitempty" yaml:"serveStale,omitempty"`
+
+	// StaleMaxAge is maximum age of last successful response that is served while circuit is open,
+	// request fails when response is older. Defaults to 5 minutes.
+	StaleMaxAge *time.Duration `json:"staleMaxAge,omitempty" yaml:"staleMaxAge,omitempty"`
+}
+
+// OnLimitAction is action taken when request can't be sent immediately because of rate limits.
+type OnLimitAction string
+
+const (
+	// OnLimitWait waits for permit up to RateLimitConfig.MaxWait
+	OnLimitWait = OnLimitAction("wait")
+	// OnLimitStale serves last successful response of same request, if there is one, waits otherwise.
+	OnLimitStale = OnLimitAction("stale")
+	// OnLimitFail fails request immediately
+	OnLimitFail = OnLimitAction("fail")
+)
+
+// RateLimitConfig configures token-bucket rate limit and maximum number of in-flight requests.
+type RateLimitConfig struct {
+	// Rate is number of requests per second. Zero means no rate limit.
+	Rate *float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
+
+	// Burst is maximum number of requests sent at once, when rate limit allows. Defaults to 1.
+	Burst *int `json:"burst,omitempty" yaml:"burst,omitempty"`
+
+	// MaxInFlight is maximum number of concurrent requests. Zero means no limit.
+	MaxInFlight *int `json:"maxInFlight,omitempty" yaml:"maxInFlight,omitempty"`
+
+	// PerHost flag to apply limits to every host separately rather than to whole client. Defaults to true.
+	PerHost *bool `json:"perHost,omitempty" yaml:"perHost,omitempty"`
+
+	// OnLimit is action taken when limit is hit, one of "wait", "stale" or "fail". Defaults to "wait".
+	OnLimit *OnLimitAction `json:"onLimit,omitempty" yaml:"onLimit,omitempty"`
+
+	// MaxWait is maximum time to wait for permit. Waiting is always bounded by client timeout.
+	MaxWait *time.Duration `json:"maxWait,omitempty" yaml:"maxWait,omitempty"`
+
+	// StaleMaxAge is maximum age of last successful response that is served with "stale" action,
+	// older response is not served and request waits instead. Defaults to 5 minutes.
+	StaleMaxAge *time.Duration `json:"staleMaxAge,omitempty" yaml:"staleMaxAge,omitempty"`
+}
+
+// RetryConfig configures retry of failed requests with exponential backoff.
+// All attempts together are bounded by client timeout.
+type RetryConfig struct {
+	// MaxAttempts is maximum number of attempts, including the first one.
+	MaxAttempts *int `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`
+
+	// StatusCodes are response status codes that are retried. Transport errors are always retried.
+	StatusCodes []int `json:"statusCodes,omitempty" yaml:"statusCodes,omitempty"`
+
+	// BaseBackoff is delay before first retry. It is doubled on every subsequent retry.
+	BaseBackoff *time.Duration `json:"baseBackoff,omitempty" yaml:"baseBackoff,omitempty"`
+
+	// MaxBackoff is upper bound of delay between attempts.
+	MaxBackoff *time.Duration `json:"maxBackoff,omitempty" yaml:"maxBackoff,omitempty"`
+
+	// Jitter is fraction of backoff (0 to 1) that is randomized.
+	Jitter *float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+
+	// RetryAfter flag to honor Retry-After response header.
+	RetryAfter *bool `json:"retryAfter,omitempty" yaml:"retryAfter,omitempty"`
+
+	// Methods are request methods that are retried. Defaults to idempotent GET, HEAD, OPTIONS, PUT and DELETE.
+	// Other methods, such as POST, must be opted in explicitly, as they may be repeated after server processed them.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+}
+
+// ProxyConfig configures HTTP or SOCKS5 proxy for outgoing requests.
+type ProxyConfig struct {
+	// Url is URL of proxy server, such as "http://proxy:3128" or "socks5://proxy:1080"
+	Url *string `json:"url,omitempty" yaml:"url,omitempty"`
+
+	// FromEnvironment flag to use proxy from HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	// It takes precedence over Url.
+	FromEnvironment *bool `json:"fromEnvironment,omitempty" yaml:"fromEnvironment,omitempty"`
+
+	// NoProxy is list of hosts, domains, IP addresses or CIDRs that are accessed directly, bypassing proxy.
+	// Syntax is same as of NO_PROXY environment variable.
+	NoProxy []string `json:"noProxy,omitempty" yaml:"noProxy,omitempty"`
+
+	// ConnectHeaders are headers sent to proxy server in CONNECT requests
+	ConnectHeaders map[string]string `json:"connectHeaders,omitempty" yaml:"connectHeaders,omitempty"`
+}
+
+// TLSConfig configures TLS settings of outgoing connections.
+// Certificate files are watched for changes and reloaded when rotated on disk.
+type TLSConfig struct {
+	// CaFile is path to PEM file with CA certificate(s) used to verify server certificate.
+	// When omitted, system roots are used.
+	CaFile *string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+
+	// CertFile is path to PEM file with client certificate, used for mutual TLS
+	CertFile *string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+
+	// KeyFile is path to PEM file with private key of client certificate
+	KeyFile *string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+
+	// ServerName is used to verify hostname of server certificate and is sent as SNI
+	ServerName *string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+
+	// InsecureSkipVerify disables verification of server certificate
+	InsecureSkipVerify *bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+
+	// MinVersion is minimal TLS version, one of "TLS10", "TLS11", "TLS12" or "TLS13"
+	MinVersion *string `json:"minVersion,omitempty" yaml:"minVersion,omitempty"`
+}
+
+// OAuth2Config configures OAuth2 client credentials flow.
+// Token is fetched on first request and refreshed automatically when it expires.
+type OAuth2Config struct {
+	// TokenUrl is URL of token endpoint
+	TokenUrl string `json:"tokenUrl" yaml:"tokenUrl"`
+
+	// ClientId is application's ID
+	ClientId string `json:"clientId" yaml:"clientId"`
+
+	// ClientSecret is application's secret
+	ClientSecret *string `json:"clientSecret,omitempty" yaml:"clientSecret,omitempty"`
+
+	// ClientSecretFile is path to file that contains application's secret. It takes precedence over ClientSecret.
+	ClientSecretFile *string `json:"clientSecretFile,omitempty" yaml:"clientSecretFile,omitempty"`
+
+	// Scopes specifies optional requested permissions
+	Scopes []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+
+	// EndpointParams specifies additional parameters for requests to the token endpoint
+	EndpointParams map[string]string `json:"endpointParams,omitempty" yaml:"endpointParams,omitempty"`
+}
+
+// ClientSecretValue gets client secret, either from file or inline value.
+func (o *OAuth2Config) ClientSecretValue() (string, error) {
+	return readSecret(o.ClientSecret, o.ClientSecretFile)
+}
+
+// AuthConfig configures authentication of outgoing requests.
+// At most one authentication method should be configured.
+type AuthConfig struct {
+	// Basic configures HTTP basic authentication
+	Basic *BasicAuthConfig `json:"basic,omitempty" yaml:"basic,omitempty"`
+
+	// Bearer configures bearer token authentication
+	Bearer *BearerAuthConfig `json:"bearer,omitempty" yaml:"bearer,omitempty"`
+}
+
+// BasicAuthConfig configures HTTP basic authentication.
+// Every value can be read from file, which takes precedence over inline value.
+type BasicAuthConfig struct {
+	// Username is username to authenticate with
+	Username *string `json:"username,omitempty" yaml:"username,omitempty"`
+
+	// UsernameFile is path to file that contains username
+	UsernameFile *string `json:"usernameFile,omitempty" yaml:"usernameFile,omitempty"`
+
+	// Password is password to authenticate with
+	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	// PasswordFile is path to file that contains password
+	PasswordFile *string `json:"passwordFile,omitempty" yaml:"passwordFile,omitempty"`
+}
+
+// BearerAuthConfig configures bearer token authentication.
+type BearerAuthConfig struct {
+	// Token is bearer token
+	Token *string `json:"token,omitempty" yaml:"token,omitempty"`
+
+	// TokenFile is path to file that contains bearer token. It takes precedence over Token.
+	TokenFile *string `json:"tokenFile,omitempty" yaml:"tokenFile,omitempty"`
+}
+
+// readSecret reads secret from file if configured, otherwise inline value is used.
+// Files are read on every call, so that rotated secrets are picked up.
+func readSecret(inline, file *string) (string, error) {
+	if file != nil && len(*file) > 0 {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if inline != nil {
+		return *inline, nil
+	}
+	return "", nil
+}
+
+// Apply sets authorization on given request.
+func (a *AuthConfig) Apply(req *http.Request) error {
+	switch {
+	case a.Basic != nil:
+		user, err := readSecret(a.Basic.Username, a.Basic.UsernameFile)
+		if err != nil {
+			return err
+		}
+		pass, err := readSecret(a.Basic.Password, a.Basic.PasswordFile)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(user, pass)
+	case a.Bearer != nil:
+		token, err := readSecret(a.Bearer.Token, a.Bearer.TokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// String returns type of authentication only, credentials are never included.
+func (a *AuthConfig) String() string {
+	switch {
+	case a.Basic != nil:
+		return "basic"
+	case a.Bearer != nil:
+		return "bearer"
+	}
+	return "none"
+}
+
+// LogValue implements slog.LogValuer, so that credentials are never logged.
+func (a *AuthConfig) LogValue() slog.Value {
+	return slog.StringValue(a.String())
 }
 
 type InstrumentationConfigFragment struct {
@@ -79,6 +433,37 @@ type InstrumentationConfigFragment struct {
 	Prefix *string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
 }
 
+// CacheMode determines how freshness of cached responses is computed.
+type CacheMode string
+
+const (
+	// CacheModeTTL caches every response for configured TTL.
+	CacheModeTTL = CacheMode("ttl")
+	// CacheModeHttp follows HTTP caching semantics (RFC 9111).
+	// Freshness is taken from Cache-Control and Expires response headers, configured TTL is used only
+	// when server provides none. Stale responses with ETag or Last-Modified are revalidated using conditional request.
+	CacheModeHttp = CacheMode("http")
+)
+
+// CacheBackend is storage of cached responses.
+type CacheBackend string
+
+const (
+	// CacheBackendMemory keeps cached responses in memory only.
+	CacheBackendMemory = CacheBackend("memory")
+	// CacheBackendDisk additionally persists cached responses on disk, so that they survive restart.
+	CacheBackendDisk = CacheBackend("disk")
+)
+
+// DiskCacheConfig configures disk backend of response cache.
+type DiskCacheConfig struct {
+	// Path is directory where cached responses are stored. It's created if it doesn't exist.
+	Path *string `json:"path,omitempty" yaml:"path,omitempty"`
+
+	// MaxBytes is maximum total size of cached responses on disk. Zero means no limit.
+	MaxBytes *int64 `json:"maxBytes,omitempty" yaml:"maxBytes,omitempty"`
+}
+
 // CacheConfig is used to configure TTL cache for HTTP responses.
 type CacheConfig struct {
 	// Enabled specifies whether to enable cache or not.
@@ -91,6 +476,31 @@ type CacheConfig struct {
 	// Capacity determines max. number of items in cache
 	Capacity *int `json:"capacity,omitempty" yaml:"capacity,omitempty"`
 
+	// Mode determines how freshness of cached responses is computed, either "ttl" (default) or "http".
+	Mode *CacheMode `json:"mode,omitempty" yaml:"mode,omitempty"`
+
+	// Methods are request methods whose responses are cached. Defaults to GET and HEAD.
+	// Other methods, such as POST queries, must be opted in explicitly.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+
+	// VaryHeaders are request headers that are part of cache key, in addition to method, URL and request body.
+	// Defaults to Authorization and Accept. Headers listed in Vary response header are always part of cache key.
+	VaryHeaders []string `json:"varyHeaders,omitempty" yaml:"varyHeaders,omitempty"`
+
+	// StaleWhileRevalidate is time after response became stale, during which it's served from cache
+	// while being refreshed in background.
+	StaleWhileRevalidate *time.Duration `json:"staleWhileRevalidate,omitempty" yaml:"staleWhileRevalidate,omitempty"`
+
+	// StaleIfError is time after response became stale, during which it's served from cache
+	// when request fails or server responds with 5xx status.
+	StaleIfError *time.Duration `json:"staleIfError,omitempty" yaml:"staleIfError,omitempty"`
+
+	// Backend is storage of cached responses, either "memory" (default) or "disk".
+	Backend *CacheBackend `json:"backend,omitempty" yaml:"backend,omitempty"`
+
+	// Disk configures disk backend
+	Disk *DiskCacheConfig `json:"disk,omitempty" yaml:"disk,omitempty"`
+
 	// Instrumentation enables cache instrumentation
 	Instrumentation *InstrumentationConfigFragment `json:"instrumentation,omitempty" yaml:"instrumentation,omitempty"`
 }
@@ -119,12 +529,52 @@ type ServerConfig struct {
 
 	// MetricsPath HTTP route for handling metrics. Default value is /metrics
 	MetricsPath *string `json:"metricsPath,omitempty" yaml:"metricsPath,omitempty"`
+
+	// Admin configures admin endpoints. They are disabled when not set.
+	Admin *AdminConfig `json:"admin,omitempty" yaml:"admin,omitempty"`
+}
+
+// AdminConfig configures admin endpoints, which allow to inspect and purge response cache.
+type AdminConfig struct {
+	// Path is HTTP route prefix of admin endpoints. Default value is /admin
+	Path *string `json:"path,omitempty" yaml:"path,omitempty"`
+
+	// Token that must be presented as bearer token in Authorization header.
+	// Either Token or TokenFile is required.
+	Token *string `json:"token,omitempty" yaml:"token,omitempty"`
+
+	// TokenFile is path to file containing token
+	TokenFile *string `json:"tokenFile,omitempty" yaml:"tokenFile,omitempty"`
+}
+
+// TokenValue gets admin token, either from file or inline value.
+func (a *AdminConfig) TokenValue() (string, error) {
+	return readSecret(a.Token, a.TokenFile)
+}
+
+// CacheEntry describes entry in HTTP response cache.
+type CacheEntry struct {
+	// Key is cache key, which consists of method, URL and optional hash of request body and headers.
+	Key string `json:"key"`
+	// StoredAt is time when response was received or last revalidated.
+	StoredAt time.Time `json:"storedAt"`
+	// FreshUntil is time until which response is used without contacting server.
+	FreshUntil time.Time `json:"freshUntil"`
+	// ExpiresAt is time when entry is removed from cache.
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Size is size of response body in bytes.
+	Size int `json:"size"`
 }
 
 type Config struct {
 	// HttpClient configures HttpClientService
 	HttpClient *HttpClientServiceConfig `json:"httpClient" yaml:"httpClient"`
 
+	// HttpClients is map of named HTTP client profiles.
+	// Profile can be selected in http_fetch using "client" argument.
+	// Unset values of profile are taken from defaults, not from HttpClient.
+	HttpClients map[string]*HttpClientServiceConfig `json:"httpClients,omitempty" yaml:"httpClients,omitempty"`
+
 	// Server is server configuration
 	Server *ServerConfig `json:"server,omitempty" yaml:"server,omitempty"`
 
@@ -148,12 +598,63 @@ type ParsedHttpResponse struct {
 	StatusCode int
 	Header     http.Header
 	Body       []byte
+	// Url is final URL of request, after all redirects were followed.
+	Url string
+	// Proto is protocol version, such as "HTTP/1.1"
+	Proto string
+	// ContentLength is length of response body as reported by server.
+	ContentLength int64
+	// StoredAt is time when response was received or last revalidated.
+	StoredAt time.Time
+	// FreshUntil is time until which cached response can be used without contacting server.
+	FreshUntil time.Time
+	// Stale is true when response was served from cache after it became stale.
+	Stale bool
+}
+
+// NewParsedHttpResponse creates ParsedHttpResponse from http.Response and its already consumed body.
+func NewParsedHttpResponse(resp *http.Response, body []byte) *ParsedHttpResponse {
+	r := &ParsedHttpResponse{
+		StatusCode:    resp.StatusCode,
+		Header:        resp.Header,
+		Body:          body,
+		Proto:         resp.Proto,
+		ContentLength: resp.ContentLength,
+		StoredAt:      time.Now(),
+	}
+	if _, r.Stale = resp.Header[StaleHeader]; r.Stale {
+		r.Header = resp.Header.Clone()
+		r.Header.Del(StaleHeader)
+	}
+	if r.ContentLength < 0 {
+		r.ContentLength = int64(len(body))
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		r.Url = resp.Request.URL.String()
+	}
+	return r
+}
+
+// IsFresh checks whether response can be used from cache at given time without revalidation.
+func (r *ParsedHttpResponse) IsFresh(now time.Time) bool {
+	return now.Before(r.FreshUntil)
 }
 
 func (r *ParsedHttpResponse) AsHttpResponse() *http.Response {
-	return &http.Response{
-		StatusCode: r.StatusCode,
-		Header:     r.Header,
-		Body:       io.NopCloser(bytes.NewReader(r.Body)),
+	resp := &http.Response{
+		StatusCode:    r.StatusCode,
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		Proto:         r.Proto,
+		Header:        r.Header,
+		ContentLength: r.ContentLength,
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+	}
+	if r.Stale {
+		resp.Header = r.Header.Clone()
+		resp.Header.Set(StaleHeader, "true")
+	}
+	if u, err := url.Parse(r.Url); err == nil && len(r.Url) > 0 {
+		resp.Request = &http.Request{URL: u}
 	}
+	return resp
 }